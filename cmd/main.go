@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
@@ -11,15 +12,40 @@ import (
 	"github.com/Ser9unin/ImagePreviewer/internal/cache"
 	"github.com/Ser9unin/ImagePreviewer/internal/config"
 	"github.com/Ser9unin/ImagePreviewer/internal/logger"
+	"github.com/Ser9unin/ImagePreviewer/internal/peer"
+	"github.com/Ser9unin/ImagePreviewer/internal/redis"
 	"github.com/Ser9unin/ImagePreviewer/internal/server"
+	"github.com/Ser9unin/ImagePreviewer/internal/source"
 	"golang.org/x/sync/errgroup"
 )
 
 func main() {
 	logger := logger.NewLogger()
 	config := config.New()
-	cache := cache.NewCache(config.Cache)
-	app := app.New(cache, logger)
+	if err := cache.CheckPolicy(config.Cache.Policy); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	cache := cache.New[string, app.Entry](config.Cache)
+	sources := source.NewDefault(config, logger)
+	app := app.New(config, cache, sources, logger)
+	shared, err := redis.NewClient(config.Redis)
+	switch {
+	case err == nil:
+		defer shared.Close()
+		if err := app.ShareCache(shared, config.Redis); err != nil {
+			logger.Error(fmt.Sprintf("shared cache disabled: %s", err))
+		}
+	case !errors.Is(err, redis.ErrNotConfigured):
+		logger.Error(fmt.Sprintf("shared cache disabled: %s", err))
+	}
+	peers, err := peer.NewPool(config.Peer)
+	switch {
+	case err == nil:
+		app.SetPeers(peers)
+	case !errors.Is(err, peer.ErrNotConfigured):
+		logger.Error(fmt.Sprintf("peers disabled: %s", err))
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -45,4 +71,7 @@ func main() {
 	if err := g.Wait(); err != nil {
 		fmt.Printf("exit reason: %s \n", err)
 	}
+	// фоновые удаления превью должны завершиться, иначе после перезапуска они вернутся в кэш
+	app.Close()
+	cache.Close()
 }
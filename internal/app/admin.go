@@ -0,0 +1,132 @@
+package app
+
+import (
+	"sort"
+	"strings"
+)
+
+// CacheEntry превью в кэше вместе с его ключом.
+type CacheEntry struct {
+	Key string
+	Entry
+}
+
+// RemoteKeys кэш с общим хранилищем, которое умеет отбирать ключи по шаблону.
+type RemoteKeys interface {
+	// RemoteKeys возвращает ключи общего хранилища, подходящие под шаблон SCAN MATCH.
+	RemoteKeys(pattern string) []string
+}
+
+// Entries возвращает страницу превью в кэше, упорядоченных по ключу,
+// начиная с offset, не больше limit, и общее число превью.
+// Превью из общего хранилища, которых нет в кэше этого экземпляра, не учитываются.
+func (app *App) Entries(offset, limit int) ([]CacheEntry, int) {
+	keys := app.cache.LocalKeys()
+	sort.Strings(keys)
+	entries := make([]CacheEntry, 0, len(keys))
+	for _, key := range keys {
+		// ключ мог покинуть кэш после снимка ключей
+		if entry, ok := app.cache.Peek(key); ok {
+			entries = append(entries, CacheEntry{Key: key, Entry: entry})
+		}
+	}
+	total := len(entries)
+
+	offset = min(max(offset, 0), total)
+	return entries[offset:min(offset+max(limit, 0), total)], total
+}
+
+// Entry возвращает превью по точному ключу кэша, не отмечая обращение к нему.
+func (app *App) Entry(key string) (Entry, bool) {
+	return app.cache.Peek(key)
+}
+
+// Delete удаляет превью по точному ключу кэша вместе с его файлом.
+func (app *App) Delete(key string) bool {
+	return app.cache.Remove(key)
+}
+
+// PurgeSource удаляет все превью исходника ref (любых размеров), сам исходник из памяти
+// и сведения о нем, так что следующий запрос скачает исходник заново.
+// Ссылка нормализуется так же, как в запросе превью, схема http(s):// отбрасывается.
+func (app *App) PurgeSource(ref string) int {
+	ref = normalizeRef(ref)
+	if ref == "" {
+		return 0
+	}
+	return app.purge(func(key string) bool { return key == ref }, globEscape(ref))
+}
+
+// PurgeHost удаляет превью всех исходников с хоста host.
+func (app *App) PurgeHost(host string) int {
+	host = strings.ToLower(strings.Trim(trimScheme(host), "/"))
+	if host == "" {
+		return 0
+	}
+	return app.PurgePrefix(host + "/")
+}
+
+// PurgePrefix удаляет превью исходников, ссылки на которые начинаются с prefix,
+// например, example.com/photos/.
+func (app *App) PurgePrefix(prefix string) int {
+	normalized := normalizeRef(prefix)
+	if normalized == "" {
+		return 0
+	}
+	// нормализация отбрасывает завершающий "/", а он отделяет каталог от одноименных файлов
+	if strings.HasSuffix(prefix, "/") {
+		normalized += "/"
+	}
+	return app.purge(func(key string) bool { return strings.HasPrefix(key, normalized) }, globEscape(normalized)+"*")
+}
+
+// Clear удаляет все превью из кэша.
+func (app *App) Clear() {
+	app.cache.Clear()
+}
+
+// purge удаляет превью исходников, ссылки на которые подходят под match,
+// и забывает сами исходники. Возвращает число удаленных превью.
+// Из общего хранилища перебираются только ключи, подходящие под шаблон ссылки refPattern.
+func (app *App) purge(match func(ref string) bool, refPattern string) int {
+	keys := app.cache.LocalKeys()
+	if remote, ok := app.cache.(RemoteKeys); ok {
+		keys = append(keys, remote.RemoteKeys("/*/*/*/"+refPattern)...)
+	}
+	purged := 0
+	for _, key := range keys {
+		if match(sourceOf(key)) && app.cache.Remove(key) {
+			purged++
+		}
+	}
+	app.origins.removeMatching(match)
+	app.index.removeMatching(match)
+	return purged
+}
+
+// globEscape экранирует в s спецсимволы шаблона SCAN MATCH.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\^`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// normalizeRef приводит ссылку на исходник к виду, в котором она входит в ключ кэша.
+func normalizeRef(ref string) string {
+	return sourceOf(transformKey("/fill/1/1/" + trimScheme(ref)))
+}
+
+// trimScheme отбрасывает схему http:// или https:// в начале ссылки.
+func trimScheme(ref string) string {
+	for _, scheme := range []string{"http://", "https://"} {
+		if len(ref) >= len(scheme) && strings.EqualFold(ref[:len(scheme)], scheme) {
+			return ref[len(scheme):]
+		}
+	}
+	return ref
+}
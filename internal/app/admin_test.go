@@ -0,0 +1,85 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminPurge(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	app := newTestApp(config.Config{
+		Upstream: config.UpstreamCfg{Timeout: 5 * time.Second, RetryAttempts: 1},
+		Cache:    config.CacheCfg{OriginMaxBytes: 1 << 20},
+		Storage:  config.StorageCfg{Path: t.TempDir()},
+	})
+	host := strings.TrimPrefix(srv.URL, "http://")
+	key := "/fill/50/40/" + host + "/a/beaver.jpg"
+	fill := func(paths ...string) {
+		for _, path := range paths {
+			_, err := app.Preview(context.Background(), path, http.Header{})
+			require.NoError(t, err)
+		}
+	}
+	fill(
+		key,
+		"/fill/60/40/"+host+"/a/beaver.jpg",
+		"/fill/50/40/"+host+"/b/beaver.jpg",
+		"/fill/50/40/"+host+"/ab/beaver.jpg",
+	)
+
+	// страницы упорядочены по ключу
+	page, total := app.Entries(1, 2)
+	require.Equal(t, 4, total)
+	require.Len(t, page, 2)
+	require.Equal(t, "/fill/50/40/"+host+"/ab/beaver.jpg", page[0].Key)
+	require.Equal(t, "/fill/50/40/"+host+"/b/beaver.jpg", page[1].Key)
+	page, _ = app.Entries(10, 2)
+	require.Empty(t, page)
+
+	entry, ok := app.Entry(key)
+	require.True(t, ok)
+	require.Equal(t, previewFileName(key), entry.Path())
+
+	// все размеры исходника удаляются вместе с самим исходником
+	require.Equal(t, 2, app.PurgeSource("http://"+host+"/a/beaver.jpg"))
+	_, ok = app.Entry(key)
+	require.False(t, ok)
+	_, ok = app.origins.get(host + "/a/beaver.jpg")
+	require.False(t, ok)
+	_, ok = app.index.expiry(host + "/a/beaver.jpg")
+	require.False(t, ok)
+	_, ok = app.origins.get(host + "/b/beaver.jpg")
+	require.True(t, ok)
+
+	// каталог /a/ не захватывает /ab/
+	fill(key)
+	require.Equal(t, 1, app.PurgePrefix(host+"/a/"))
+	require.Equal(t, 2, len(app.cache.Keys()))
+
+	require.Equal(t, 2, app.PurgeHost("HTTP://"+strings.ToUpper(host)))
+	require.Empty(t, app.cache.Keys())
+
+	fill(key)
+	require.True(t, app.Delete(key))
+	require.False(t, app.Delete(key))
+
+	fill(key, "/fill/50/40/"+host+"/b/beaver.jpg")
+	app.Clear()
+	require.Empty(t, app.cache.Keys())
+}
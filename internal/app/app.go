@@ -1,33 +1,101 @@
 package app
 
 import (
-	"bufio"
 	"bytes"
 	"context"
-	"errors"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"image/jpeg"
-	"io"
-	"log"
 	"net/http"
-	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Ser9unin/ImagePreviewer/internal/cache"
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/Ser9unin/ImagePreviewer/internal/source"
 	"github.com/disintegration/imaging"
+	"golang.org/x/sync/singleflight"
 )
 
-var storagePath = "./internal/storage/"
+// defaultStoragePath каталог превью, если он не задан в настройках.
+const defaultStoragePath = "./internal/storage/"
+
+// previewFormat формат, в котором сохраняются превью, previewContentType - его тип содержимого.
+const (
+	previewFormat      = "jpeg"
+	previewContentType = "image/jpeg"
+)
 
 type App struct {
-	cache  Cache
-	logger Logger
+	cache    Cache
+	logger   Logger
+	sources  Sources
+	upstream config.UpstreamCfg
+	// ttl срок жизни превью в кэше, если источник не указал свой.
+	ttl time.Duration
+	// previews объединяет одновременные одинаковые запросы превью,
+	// чтобы источник скачивался и обрабатывался один раз.
+	previews singleflight.Group
+	// fetches объединяет одновременные загрузки одного источника (например, для разных размеров).
+	fetches singleflight.Group
+	// origins исходные изображения, чтобы новые размеры делались без повторной загрузки.
+	origins *originCache
+	// index валидаторы и сроки свежести исходников и связанные с ними превью.
+	index *sourceIndex
+	// files превью на диске, значения кэша ссылаются на них.
+	files *fileStore
+	// hot байты часто запрашиваемых превью в памяти перед файлами на диске.
+	hot *hotCache
+	// peers другие экземпляры сервиса, между которыми распределены превью, nil - не используются.
+	peers Peers
 }
 
+// Cache кэш превью по ключу преобразования.
 type Cache interface {
-	Set(key string, value interface{}) bool
-	Get(key string) (interface{}, bool)
+	Set(key string, value Entry) bool
+	SetItem(key string, value Entry, opts cache.ItemOptions) bool
+	Get(key string) (Entry, bool)
+	Peek(key string) (Entry, bool)
+	Keys() []string
+	// LocalKeys ключи превью этого экземпляра, без перебора общего хранилища.
+	LocalKeys() []string
+	Remove(key string) bool
 	Clear()
+	OnEvict(fn cache.EvictFunc[string, Entry])
+	Stats() cache.Stats
+}
+
+// Entry запись кэша превью: файл превью на диске и сведения о нем.
+type Entry struct {
+	// Size объем файла в байтах.
+	Size        int64
+	ContentType string
+	// ETag и LastModified валидаторы исходника, из которого сделано превью
+	// (пустые у присланных изображений).
+	ETag         string
+	LastModified string
+	// Expires момент устаревания превью в кэше, нулевое значение - без срока.
+	Expires time.Time
+
+	file storedFile
+}
+
+// Path путь файла превью относительно каталога превью.
+func (e Entry) Path() string {
+	return e.file.path
+}
+
+// newEntry запись кэша для файла превью file объемом size с описанием meta.
+func newEntry(file storedFile, size int64, meta previewMeta) Entry {
+	entry := Entry{Size: size, ContentType: previewContentType, Expires: meta.Expires, file: file}
+	if meta.Source != nil {
+		entry.ETag = meta.Source.ETag
+		entry.LastModified = meta.Source.LastModified
+	}
+	return entry
 }
 
 type Logger interface {
@@ -37,27 +105,140 @@ type Logger interface {
 	Warn(msg string)
 }
 
-func New(cache Cache, logger Logger) *App {
-	return &App{cache: cache, logger: logger}
+// Sources выбирает источник по ссылке на исходник и возвращает ссылку без префикса источника.
+type Sources interface {
+	Resolve(ref string) (source.Source, string)
 }
 
-func (app *App) Set(key string, value interface{}) bool {
-	return app.cache.Set(key, value)
+// Peers другие экземпляры сервиса, между которыми превью распределены по ключу.
+type Peers interface {
+	// Owner возвращает экземпляр, которому принадлежит ключ, и true, если это другой экземпляр.
+	Owner(key string) (string, bool)
+	// Fetch получает превью по ключу у экземпляра peer. Ответы владельца с ошибкой возвращаются
+	// как *PreviewError, другие ошибки означают, что владелец недоступен.
+	Fetch(ctx context.Context, peer, key string, header http.Header) (*Preview, error)
 }
 
-func (app *App) Get(key string) (interface{}, bool) {
-	return app.cache.Get(key)
+func New(cfg config.Config, cache Cache, sources Sources, logger Logger) *App {
+	storagePath := cfg.Storage.Path
+	if storagePath == "" {
+		storagePath = defaultStoragePath
+	}
+	// превью, запись которых прервал сбой, кэшу неизвестны, их временные файлы не нужны
+	removed, err := removeTempFiles(storagePath)
+	if err != nil {
+		logger.Error(fmt.Sprintf("failed to remove temporary files: %s", err))
+	} else if removed > 0 {
+		logger.Info(fmt.Sprintf("removed %d temporary files", removed))
+	}
+
+	app := &App{
+		cache:    cache,
+		logger:   logger,
+		sources:  sources,
+		upstream: cfg.Upstream,
+		ttl:      cfg.Cache.TTL,
+		origins:  newOriginCache(cfg.Cache.OriginMaxBytes),
+		index:    newSourceIndex(),
+		files:    newFileStore(storagePath, logger),
+		hot:      newHotCache(cfg.Cache.MemoryMaxBytes),
+	}
+	app.origins.onEvict = app.index.dropUnused
+	cache.OnEvict(app.evicted)
+	app.restore()
+	return app
 }
 
-func (app *App) Clear() {
-	app.cache.Clear()
+// Close дожидается фоновой работы с файлами превью: удалений вытесненных и удаленных превью
+// и записей обращений к ним. Вызывается после остановки серверов, до закрытия кэша.
+// Превью, вытесненные после Close, удаляются с диска сразу.
+func (app *App) Close() {
+	app.files.close()
 }
 
-func (app *App) Fill(byteImg []byte, paramsStr string) ([]byte, error) {
-	width, height, filename, err := parseParams(paramsStr)
+// SetPeers распределяет превью между экземплярами сервиса: превью, принадлежащие
+// другому экземпляру, запрашиваются у него. Вызывается до начала обработки запросов.
+func (app *App) SetPeers(peers Peers) {
+	app.peers = peers
+}
+
+// restore заполняет кэш превью, сохраненными на диске до перезапуска,
+// от давно использованных к недавним, чтобы порядок вытеснения сохранился.
+// Устаревшие за время простоя превью удаляются.
+// Превью, сделанные из разных версий одного исходника, кроме последней, удаляются.
+func (app *App) restore() {
+	entries, err := app.files.load()
 	if err != nil {
-		return nil, err
+		app.logger.Error(fmt.Sprintf("failed to restore cache: %s", err))
+		return
+	}
+
+	// последнее известное состояние каждого исходника
+	latest := make(map[string]sourceState)
+	for _, entry := range entries {
+		if state := entry.meta.Source; state != nil {
+			ref := sourceOf(entry.meta.Key)
+			if known, ok := latest[ref]; !ok || state.Expires.After(known.Expires) {
+				latest[ref] = *state
+			}
+		}
+	}
+
+	restored := 0
+	now := time.Now()
+	for _, entry := range entries {
+		var ttl time.Duration
+		if !entry.meta.Expires.IsZero() {
+			if ttl = entry.meta.Expires.Sub(now); ttl <= 0 {
+				app.files.removeAsync(entry.file)
+				continue
+			}
+		}
+		ref := sourceOf(entry.meta.Key)
+		if state := entry.meta.Source; state != nil {
+			if state.Digest != latest[ref].Digest {
+				app.files.removeAsync(entry.file)
+				continue
+			}
+			app.index.restore(ref, entry.meta.Key, *state)
+		}
+		app.cache.SetItem(entry.meta.Key, newEntry(entry.file, entry.size, entry.meta),
+			cache.ItemOptions{Weight: entry.size, TTL: ttl})
+		restored++
+	}
+	if restored > 0 {
+		app.logger.Info(fmt.Sprintf("restored %d previews from disk", restored))
+	}
+}
+
+// evicted удаляет из памяти и с диска превью, покинувшее кэш, и забывает его в учете исходников.
+func (app *App) evicted(key string, entry Entry) {
+	app.logger.Info(fmt.Sprintf("preview evicted from cache: %s", key))
+	app.hot.remove(key)
+	app.files.removeAsync(entry.file)
+	app.index.removeVariant(sourceOf(key), key, app.origins.contains)
+}
+
+// Metrics возвращает состояние приложения для отчета в /metrics.
+func (app *App) Metrics() map[string]interface{} {
+	metrics := map[string]interface{}{}
+	if reporter, ok := app.sources.(source.Reporter); ok {
+		metrics = reporter.Metrics()
+	}
+	report := statsReport(app.cache.Stats())
+	for tier, stats := range app.hot.metrics() {
+		report[tier] = stats
 	}
+	metrics["cache"] = report
+	return metrics
+}
+
+// fill делает превью размером width x height и сохраняет его на диск и в кэш по ключу paramsStr.
+// Превью хранится в кэше не дольше ttl, 0 - без срока. digest - хэш byteImg: если исходник
+// тем временем изменился в учете исходников, превью отдается без кэширования.
+// Пустой digest у изображений, присланных клиентом, они в учете исходников не участвуют.
+func (app *App) fill(byteImg []byte, digest, paramsStr string, width, height int, ttl time.Duration) ([]byte, error) {
+	filename := previewFileName(paramsStr)
 
 	rawJpeg := bytes.NewReader(byteImg)
 	srcImage, err := jpeg.Decode(rawJpeg)
@@ -81,178 +262,86 @@ func (app *App) Fill(byteImg []byte, paramsStr string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	app.logger.Info(fmt.Sprintf("saving file on disk: %s", filename))
-
 	// кэшуруем файлы на диске
-	err = fileStorage(bytesResponse, filename)
-	// если файл сохранить не удалесь, возвращаем клиенту картинку,
-	// а ошибку сохранения возвращаем на сервер и там логируем
+	meta := previewMeta{Key: paramsStr}
+	if ttl > 0 {
+		meta.Expires = time.Now().Add(ttl)
+	}
+	ref := sourceOf(paramsStr)
+	if digest != "" {
+		state, ok := app.index.state(ref)
+		if !ok || state.Digest != digest {
+			app.logger.Info(fmt.Sprintf("source changed while rendering, skip caching: %s", paramsStr))
+			return bytesResponse.Bytes(), nil
+		}
+		meta.Source = &state
+	}
+	app.logger.Info(fmt.Sprintf("saving file on disk: %s", filename))
+	stored, err := app.files.save(filename, bytesResponse.Bytes(), meta)
+	// если файл сохранить не удалось, возвращаем клиенту картинку без кэширования,
+	// а ошибку сохранения логируем
 	if err != nil {
-		app.logger.Error(fmt.Sprintf("failed to save file: %s", filename))
-		return bytesResponse.Bytes(), err
+		app.logger.Error(fmt.Sprintf("failed to save file %s: %s", filename, err))
+		return bytesResponse.Bytes(), nil
 	}
 	app.logger.Info(fmt.Sprintf("file saved disk: %s", filename))
 
 	// в cache Key пишем строку с параметрами и адресом исходного запроса
 	// в формате fill/width/height/jpegSource.com/sourceFileName.jpg
-	// в cache Value пишем имя файла, с которым он буде храниться на диске
-	// в формате width_height_sourceFileName.jpg.
-	app.cache.Set(paramsStr, filename)
+	// в cache Value пишем запись о файле, под которым превью хранится на диске,
+	// с путем в формате ab/cd/abcdef....jpg.
+	// Вес записи - объем файла, срок жизни - ttl.
+	size := int64(bytesResponse.Len())
+	app.cache.SetItem(paramsStr, newEntry(stored, size, meta), cache.ItemOptions{Weight: size, TTL: ttl})
+	app.hot.promote(paramsStr, stored, bytesResponse.Bytes())
+	// исходник мог измениться, пока превью сохранялось: такое превью из кэша убираем
+	if digest != "" && !app.index.addVariant(ref, paramsStr, digest) {
+		app.logger.Info(fmt.Sprintf("source changed while rendering, drop preview: %s", paramsStr))
+		app.cache.Remove(paramsStr)
+		return bytesResponse.Bytes(), nil
+	}
 	app.logger.Info(fmt.Sprintf("set cache file: %s", filename))
 
 	// клиенту возвращаем jpeg в виде байт
 	return bytesResponse.Bytes(), nil
 }
 
-// parseParams достаёт из запроса данные о ширине и высоте, до которых нужно изменить размер,
-// а так же имя файла, с которым тот будет сохранен на диске
-// в формате width_height_sourceFileName.jpg.
-func parseParams(paramsStr string) (width, height int, fileName string, err error) {
+// parseParams достаёт из запроса данные о ширине и высоте, до которых нужно изменить размер.
+func parseParams(paramsStr string) (width, height int, err error) {
 	splitParams := strings.Split(paramsStr, "/")
 	if len(splitParams) < 4 {
-		return 0, 0, "", fmt.Errorf("not enough params")
+		return 0, 0, fmt.Errorf("not enough params")
 	}
 	width, err = strconv.Atoi(splitParams[2])
 	if err != nil {
-		return 0, 0, "", fmt.Errorf("wrong width data: %w", err)
+		return 0, 0, fmt.Errorf("wrong width data: %w", err)
 	}
 	height, err = strconv.Atoi(splitParams[3])
 	if err != nil {
-		return 0, 0, "", fmt.Errorf("wrong height data: %w", err)
+		return 0, 0, fmt.Errorf("wrong height data: %w", err)
 	}
 	if width < 1 || height < 1 {
-		return 0, 0, "", fmt.Errorf("width or height less than 1")
-	}
-	sLen := len(splitParams) - 1
-	fileName = splitParams[2] + "x" + splitParams[3] + "_" + splitParams[sLen]
-	return width, height, fileName, nil
-}
-
-func fileStorage(bytesResponse bytes.Buffer, filename string) error {
-	_, err := os.Stat(storagePath)
-	if os.IsNotExist(err) {
-		log.Println("Папки не существует, создаём...")
-		err := os.Mkdir(storagePath, os.ModePerm)
-		if err != nil {
-			return fmt.Errorf("ошибка создания папки: %w", err)
-		}
-		log.Println("Папка создана успешно")
-	}
-	filePath := storagePath + filename
-	err = saveFileOnDisk(bytesResponse.Bytes(), filePath)
-	if err != nil {
-		return err
+		return 0, 0, fmt.Errorf("width or height less than 1")
 	}
-	return nil
+	return width, height, nil
 }
 
-func saveFileOnDisk(fileBytes []byte, filename string) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("can't create file: %w", err)
-	}
-	// записываем jpeg с новыми размерами
-	_, err = file.Write(fileBytes)
-	if err != nil {
-		return fmt.Errorf("can't create file: %w", err)
-	}
-	// закрываем файл после использования
-	defer file.Close()
-	return nil
+// previewFileName путь файла превью относительно каталога превью. Имя - хэш ключа
+// преобразования (адрес исходника, размеры) и формата, поэтому превью одноименных файлов
+// с разных хостов не перезаписывают друг друга. Файлы раскладываются по подкаталогам
+// по первым байтам хэша (ab/cd/abcdef....jpg), чтобы в одном каталоге не было миллионов файлов.
+func previewFileName(paramsStr string) string {
+	sum := sha256.Sum256([]byte(paramsStr + ";format=" + previewFormat))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(name[0:2], name[2:4], name+".jpg")
 }
 
-// ProxyRequest проксирует header исходного запроса к источнику откуда будет скачиваться изображение.
-func (app *App) ProxyHeader(targetURL string, initHeader http.Header) (*http.Request, int, error) {
-	// Создаем новый запрос к целевому сервису
-	targetURLhttps := "https://" + targetURL
-	targetReq, err := http.NewRequestWithContext(context.Background(), http.MethodGet, targetURLhttps, nil)
-	app.logger.Info(targetURLhttps)
-	if err != nil {
-		return nil, http.StatusInternalServerError, fmt.Errorf("error creating request: %w", err)
-	}
-
-	// Копируем все заголовки из исходного запроса в новый
-	for name, values := range initHeader {
-		for _, value := range values {
-			targetReq.Header.Add(name, value)
-		}
-	}
-	return targetReq, http.StatusOK, nil
-}
-
-func (app *App) FetchExternalData(targetReq *http.Request) ([]byte, int, error) {
-	// Отправляем запрос и обрабатываем ответ
-	transport := &http.Transport{
-		DisableKeepAlives: false,
-	}
-
-	client := &http.Client{Transport: transport}
-	targetResp, err := client.Do(targetReq)
-	if err != nil {
-		app.logger.Error(err.Error())
-		app.logger.Info(targetReq.RequestURI)
-		targetReq.URL.Scheme = "http"
-		targetResp, err = client.Do(targetReq)
-		if err != nil {
-			app.logger.Error(fmt.Sprintf("Status %d, %s", http.StatusBadGateway, err.Error()))
-			return nil, http.StatusBadGateway, fmt.Errorf("error sending request")
-		}
-		defer func() {
-			if err := targetResp.Body.Close(); err != nil {
-				return
-			}
-		}()
-	}
-	defer func() {
-		if err := targetResp.Body.Close(); err != nil {
-			return
-		}
-	}()
-
-	// Проверяем, что внешний сервис не ответил 404
-	if targetResp.StatusCode == http.StatusNotFound {
-		return nil, targetResp.StatusCode, fmt.Errorf("content not found")
-	}
-
-	// Проверяем, что внешний сервис отправляет jpeg, если да, то читаем его через буфер.
-	contentType := targetResp.Header.Get("Content-Type")
-	if !strings.HasPrefix(contentType, "image/jpeg") {
-		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("not a JPEG image")
-	}
-
-	// скачиваем ответ через буфер, что бы не получить слишком большой файл
-	//  и прекратить чтение при превышении лимита 100 мегабайт
-	app.logger.Info("JPEG image receiving")
-	result, status, err := app.responseBufferReader(targetResp.Body)
-	if err != nil {
-		return nil, status, err
-	}
-	app.logger.Info("JPEG image received")
-	return result, http.StatusOK, nil
-}
-
-// responseBufferReader читает файл из источника по 1 килобайту,
-// до конца файла или достижения лимита в 100 мегабайт.
-// Если лимит превышен возвращает то, что было вычитано и ошибку.
-func (app *App) responseBufferReader(targetBody io.ReadCloser) ([]byte, int, error) {
-	reader := bufio.NewReader(targetBody)
-	result := make([]byte, 0, 104857600)
-	buffer := bytes.NewBuffer(result)
-	// лимит 100 мегабайт, маловероятно что jpeg будет весить больше,
-	// если будет превышение возможно там не jpeg замаскированный под jpeg.
-	var limitBytes int64 = 104857600
-	bytesRead, err := io.CopyN(buffer, reader, 104857600)
-	if errors.Is(err, io.EOF) {
-		result = buffer.Bytes()
-		app.logger.Info(fmt.Sprintf("Received %d bytes", len(result)))
-	} else if err != nil {
-		if bytesRead > limitBytes {
-			app.logger.Info(fmt.Sprintf("Received %d bytes", len(result)))
-			result = buffer.Bytes()
-			return result, http.StatusRequestEntityTooLarge, fmt.Errorf("data exceed limit")
-		}
-		app.logger.Info(fmt.Sprintf("Received %d bytes", len(result)))
-		return nil, http.StatusNotFound, fmt.Errorf("error reading request body: %w", err)
+// sourceOf достает из ключа /fill/width/height/jpegSource.com/sourceFileName.jpg
+// ссылку на исходник jpegSource.com/sourceFileName.jpg.
+func sourceOf(paramsStr string) string {
+	splitParams := strings.Split(paramsStr, "/")
+	if len(splitParams) < 5 {
+		return ""
 	}
-	return result, http.StatusOK, nil
+	return strings.Join(splitParams[4:], "/")
 }
@@ -0,0 +1,67 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/cache"
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/Ser9unin/ImagePreviewer/internal/source"
+	"github.com/stretchr/testify/require"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string)  {}
+func (nopLogger) Error(string) {}
+func (nopLogger) Debug(string) {}
+func (nopLogger) Warn(string)  {}
+
+func newTestApp(cfg config.Config) *App {
+	return New(cfg, cache.New[string, Entry](config.CacheCfg{Capacity: 10}), source.NewDefault(cfg, nopLogger{}), nopLogger{})
+}
+
+func TestFetchOriginCoalescing(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	app := newTestApp(config.Config{Upstream: config.UpstreamCfg{Timeout: 5 * time.Second, RetryAttempts: 1}})
+	ref := strings.TrimPrefix(srv.URL, "http://") + "/beaver_cute.jpg"
+
+	const clients = 20
+	wg := &sync.WaitGroup{}
+	wg.Add(clients)
+	for i := 0; i < clients; i++ {
+		go func() {
+			defer wg.Done()
+			origin, status, err := app.fetchOrigin(context.Background(), ref, http.Header{})
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, status)
+			require.Equal(t, len(img), len(origin.data))
+		}()
+	}
+
+	// даем всем запросам присоединиться к первой загрузке
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	require.Equal(t, int32(1), calls.Load())
+}
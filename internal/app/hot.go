@@ -0,0 +1,117 @@
+package app
+
+import (
+	"sync/atomic"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/cache"
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+)
+
+// hotCache уровень кэша превью в памяти перед файлами на диске: байты часто
+// запрашиваемых превью, ограниченные суммарным объемом. Превью попадает в память
+// при создании и при чтении с диска, а вытесненное из памяти остается только на диске.
+type hotCache struct {
+	// items байты превью по ключу преобразования, nil - уровень выключен.
+	items  cache.Cache[string, hotItem]
+	memory tierStats
+	disk   tierStats
+}
+
+// hotItem байты превью и версия файла, из которого они получены.
+type hotItem struct {
+	version uint64
+	data    []byte
+}
+
+// tierStats попадания и промахи уровня кэша.
+type tierStats struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func newHotCache(maxBytes int64) *hotCache {
+	h := &hotCache{}
+	if maxBytes > 0 {
+		h.items = cache.New[string, hotItem](config.CacheCfg{MaxBytes: maxBytes})
+	}
+	return h
+}
+
+// get отдает байты превью из памяти, если они получены из текущей версии файла.
+func (h *hotCache) get(key string, file storedFile) ([]byte, bool) {
+	if h.items == nil {
+		return nil, false
+	}
+	item, ok := h.items.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if item.version != file.version {
+		return nil, false
+	}
+	return item.data, true
+}
+
+// promote переносит превью из файла file в память. Превью тяжелее всего уровня не сохраняется.
+func (h *hotCache) promote(key string, file storedFile, data []byte) {
+	if h.items != nil {
+		h.items.SetItem(key, hotItem{version: file.version, data: data}, cache.ItemOptions{Weight: int64(len(data))})
+	}
+}
+
+// remove удаляет превью из памяти, например, когда оно покинуло кэш на диске.
+func (h *hotCache) remove(key string) {
+	if h.items != nil {
+		h.items.Remove(key)
+	}
+}
+
+func (s *tierStats) record(hit bool) {
+	if hit {
+		s.hits.Add(1)
+	} else {
+		s.misses.Add(1)
+	}
+}
+
+// report попадания, промахи и доля попаданий уровня для /metrics.
+func (s *tierStats) report() map[string]interface{} {
+	hits, misses := s.hits.Load(), s.misses.Load()
+	hitRate := 0.0
+	if hits+misses > 0 {
+		hitRate = float64(hits) / float64(hits+misses)
+	}
+	return map[string]interface{}{"hits": hits, "misses": misses, "hitRate": hitRate}
+}
+
+// metrics состояние уровней кэша превью: попадания в каждый уровень,
+// а для уровня в памяти еще его размер и число вытесненных превью.
+func (h *hotCache) metrics() map[string]interface{} {
+	memory := h.memory.report()
+	if h.items != nil {
+		stats := h.items.Stats()
+		memory["entries"] = stats.Entries
+		memory["bytes"] = stats.Bytes
+		memory["evictions"] = stats.Evictions
+	}
+	return map[string]interface{}{
+		"memory": memory,
+		"disk":   h.disk.report(),
+	}
+}
+
+// statsReport счетчики кэша превью для /metrics.
+func statsReport(stats cache.Stats) map[string]interface{} {
+	return map[string]interface{}{
+		"hits":         stats.Hits,
+		"misses":       stats.Misses,
+		"hitRate":      stats.HitRate(),
+		"sets":         stats.Sets,
+		"evictions":    stats.Evictions,
+		"expirations":  stats.Expirations,
+		"entries":      stats.Entries,
+		"bytes":        stats.Bytes,
+		"remoteHits":   stats.RemoteHits,
+		"remoteErrors": stats.RemoteErrors,
+	}
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHotCache(t *testing.T) {
+	h := newHotCache(10)
+	file := storedFile{path: "a.jpg", version: 1}
+
+	h.promote("a", file, []byte("aaaaaa"))
+	data, ok := h.get("a", file)
+	require.True(t, ok)
+	require.Equal(t, "aaaaaa", string(data))
+
+	// байты другой версии файла не отдаются
+	_, ok = h.get("a", storedFile{path: "a.jpg", version: 2})
+	require.False(t, ok)
+
+	// не помещающееся в память превью вытесняет давнее, оно остается только на диске
+	h.promote("b", file, []byte("bbbbbb"))
+	_, ok = h.get("a", file)
+	require.False(t, ok)
+	_, ok = h.get("b", file)
+	require.True(t, ok)
+
+	h.promote("c", file, []byte("too large preview"))
+	_, ok = h.get("c", file)
+	require.False(t, ok)
+
+	h.remove("b")
+	_, ok = h.get("b", file)
+	require.False(t, ok)
+
+	disabled := newHotCache(0)
+	disabled.promote("a", file, []byte("a"))
+	_, ok = disabled.get("a", file)
+	require.False(t, ok)
+}
+
+func TestPreviewTiers(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		memoryMaxBytes int64
+		memoryHits     int64
+		diskHits       int64
+	}{
+		"memory":          {memoryMaxBytes: 1 << 20, memoryHits: 2},
+		"memory disabled": {memoryMaxBytes: 0, diskHits: 2},
+	} {
+		t.Run(name, func(t *testing.T) {
+			app := newTestApp(config.Config{
+				Cache:   config.CacheCfg{MemoryMaxBytes: tc.memoryMaxBytes},
+				Storage: config.StorageCfg{Path: t.TempDir()},
+			})
+			preview, err := app.PreviewUpload(context.Background(), "/fill/50/40", img)
+			require.NoError(t, err)
+			for i := 0; i < 2; i++ {
+				cached, err := app.PreviewUpload(context.Background(), "/fill/50/40", img)
+				require.NoError(t, err)
+				require.True(t, cached.FromCache)
+				require.Equal(t, preview.Data, cached.Data)
+			}
+
+			require.Equal(t, tc.memoryHits, app.hot.memory.hits.Load())
+			require.Equal(t, 3-tc.memoryHits, app.hot.memory.misses.Load())
+			require.Equal(t, tc.diskHits, app.hot.disk.hits.Load())
+			require.Equal(t, int64(1), app.hot.disk.misses.Load())
+
+			report := app.Metrics()["cache"].(map[string]interface{})
+			require.Equal(t, int64(2), report["hits"])
+			require.Equal(t, int64(1), report["misses"])
+			require.Equal(t, int64(1), report["sets"])
+			require.Equal(t, 1, report["entries"])
+			require.Equal(t, int64(len(preview.Data)), report["bytes"])
+			memory := report["memory"].(map[string]interface{})
+			require.Equal(t, tc.memoryHits, memory["hits"])
+		})
+	}
+}
+
+func TestPreviewPromotesDiskHits(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+	root := t.TempDir()
+	cfg := config.Config{Cache: config.CacheCfg{MemoryMaxBytes: 1 << 20}, Storage: config.StorageCfg{Path: root}}
+
+	_, err = newTestApp(cfg).PreviewUpload(context.Background(), "/fill/50/40", img)
+	require.NoError(t, err)
+
+	// после перезапуска превью читается с диска и переносится в память
+	app := newTestApp(cfg)
+	for i := 0; i < 2; i++ {
+		cached, err := app.PreviewUpload(context.Background(), "/fill/50/40", img)
+		require.NoError(t, err)
+		require.True(t, cached.FromCache)
+	}
+	require.Equal(t, int64(1), app.hot.disk.hits.Load())
+	require.Equal(t, int64(1), app.hot.memory.hits.Load())
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"sync"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/cache"
+	"github.com/Ser9unin/ImagePreviewer/internal/source"
+)
+
+// originCache кэш исходных изображений в памяти, ограниченный суммарным объемом в байтах.
+// Позволяет сделать превью нового размера из уже скачанного исходника, не обращаясь к источнику.
+// Вытесняются давно не использованные исходники.
+type originCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	queue    cache.List[originItem]
+	items    map[string]*cache.ListItem[originItem]
+	// onEvict вызывается вне блокировки для исходников, вытесненных при переполнении.
+	onEvict func(key string)
+}
+
+type originItem struct {
+	key    string
+	origin *source.Object
+}
+
+func newOriginCache(maxBytes int64) *originCache {
+	return &originCache{
+		maxBytes: maxBytes,
+		queue:    cache.NewListOf[originItem](),
+		items:    make(map[string]*cache.ListItem[originItem]),
+	}
+}
+
+// contains сообщает, что исходник есть в кэше, не отмечая обращение к нему.
+func (c *originCache) contains(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.items[key]
+	return ok
+}
+
+func (c *originCache) get(key string) (*source.Object, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.queue.MoveToFront(item)
+	return item.Value.origin, true
+}
+
+// removeMatching удаляет исходники, ключи которых подходят под match.
+func (c *originCache) removeMatching(match func(key string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, item := range c.items {
+		if match(key) {
+			c.size -= item.Value.origin.Size()
+			c.queue.Remove(item)
+			delete(c.items, key)
+		}
+	}
+}
+
+func (c *originCache) set(key string, origin *source.Object) {
+	// исходник больше всего кэша не сохраняем, чтобы не вытеснить им все остальные
+	if origin.Size() > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+
+	if item, ok := c.items[key]; ok {
+		c.size -= item.Value.origin.Size()
+		c.queue.Remove(item)
+	}
+	c.items[key] = c.queue.PushFront(originItem{key: key, origin: origin})
+	c.size += origin.Size()
+
+	var evicted []string
+	for c.size > c.maxBytes {
+		oldest := c.queue.Back().Value
+		c.queue.Remove(c.items[oldest.key])
+		delete(c.items, oldest.key)
+		c.size -= oldest.origin.Size()
+		evicted = append(evicted, oldest.key)
+	}
+	onEvict := c.onEvict
+	c.mu.Unlock()
+
+	if onEvict != nil {
+		for _, key := range evicted {
+			onEvict(key)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/source"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOriginCache(t *testing.T) {
+	t.Run("evicts by total size", func(t *testing.T) {
+		c := newOriginCache(10)
+		c.set("a", &source.Object{Data: make([]byte, 4)})
+		c.set("b", &source.Object{Data: make([]byte, 4)})
+
+		_, ok := c.get("a") // a становится самым свежим
+		require.True(t, ok)
+
+		c.set("c", &source.Object{Data: make([]byte, 4)})
+		_, ok = c.get("b")
+		require.False(t, ok)
+		_, ok = c.get("a")
+		require.True(t, ok)
+		_, ok = c.get("c")
+		require.True(t, ok)
+		require.Equal(t, int64(8), c.size)
+	})
+
+	t.Run("replaces existing source", func(t *testing.T) {
+		c := newOriginCache(10)
+		c.set("a", &source.Object{Data: make([]byte, 4), ETag: `"v1"`})
+		c.set("a", &source.Object{Data: make([]byte, 6), ETag: `"v2"`})
+
+		origin, ok := c.get("a")
+		require.True(t, ok)
+		require.Equal(t, `"v2"`, origin.ETag)
+		require.Equal(t, int64(6), c.size)
+	})
+
+	t.Run("skips sources larger than cache", func(t *testing.T) {
+		c := newOriginCache(10)
+		c.set("big", &source.Object{Data: make([]byte, 11)})
+		_, ok := c.get("big")
+		require.False(t, ok)
+	})
+}
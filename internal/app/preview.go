@@ -0,0 +1,416 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/source"
+)
+
+// Preview готовое превью.
+type Preview struct {
+	Data []byte
+	// FromCache превью взято из кэша, а не сделано заново из исходника.
+	FromCache bool
+	// Freshness степень свежести исходника, из которого сделано превью.
+	Freshness string
+}
+
+// PreviewError ошибка получения превью с http-статусом и пояснением для клиента.
+type PreviewError struct {
+	Status  int
+	Details string
+	Err     error
+}
+
+func (e *PreviewError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PreviewError) Unwrap() error {
+	return e.Err
+}
+
+// fetched исходник, из которого делается превью, и степень его свежести.
+type fetched struct {
+	data      []byte
+	freshness string
+	// maxAge срок хранения, указанный источником в Cache-Control, 0 - не указан.
+	maxAge time.Duration
+	// digest хэш data для сверки с учетом исходников, пустой - исходник не учитывается (загрузка клиента).
+	digest string
+}
+
+type fetchResult struct {
+	object *source.Object
+	status int
+}
+
+// uploadPrefix префикс ссылки в ключе превью изображения, присланного клиентом:
+// /fill/{width}/{height}/upload/{sha256}.jpg.
+const uploadPrefix = "upload/"
+
+// transform разобранный запрос превью.
+type transform struct {
+	// key нормализованный ключ преобразования, под ним превью хранится в кэше.
+	key string
+	// ref ссылка на исходник.
+	ref    string
+	width  int
+	height int
+}
+
+// parseTransform разбирает путь запроса /fill/{width}/{height}/{source}.
+func parseTransform(path string) (transform, error) {
+	key := transformKey(path)
+	ref := sourceOf(key)
+	if ref == "" {
+		return transform{}, &PreviewError{http.StatusBadRequest, "not correct path", fmt.Errorf("not enough params")}
+	}
+	width, height, err := parseParams(key)
+	if err != nil {
+		return transform{}, &PreviewError{http.StatusBadRequest, "fail fetch data", err}
+	}
+	return transform{key: key, ref: ref, width: width, height: height}, nil
+}
+
+// Preview отдает превью по пути запроса /fill/{width}/{height}/{source}.
+// Превью из кэша отдается после перепроверки исходника, иначе исходник скачивается
+// из источника, выбранного по ссылке, и превью делается заново.
+// Одновременные одинаковые запросы ждут результат одной загрузки.
+// Ошибки возвращаются как *PreviewError. Возвращаемые байты общие и не должны изменяться.
+// Если превью принадлежит другому экземпляру сервиса, оно запрашивается у владельца,
+// а при его недоступности делается здесь.
+func (app *App) Preview(ctx context.Context, path string, header http.Header) (*Preview, error) {
+	t, err := parseTransform(path)
+	if err != nil {
+		return nil, err
+	}
+	if preview, ok, err := app.fromPeer(ctx, t, header); ok {
+		return preview, err
+	}
+	return app.localPreview(ctx, t, header)
+}
+
+// LocalPreview отдает превью как Preview, но не обращается к другим экземплярам сервиса.
+// Так владелец превью отвечает на запросы остальных экземпляров.
+func (app *App) LocalPreview(ctx context.Context, path string, header http.Header) (*Preview, error) {
+	t, err := parseTransform(path)
+	if err != nil {
+		return nil, err
+	}
+	return app.localPreview(ctx, t, header)
+}
+
+func (app *App) localPreview(ctx context.Context, t transform, header http.Header) (*Preview, error) {
+	return app.preview(ctx, t, header, func(ctx context.Context) (fetched, int, error) {
+		return app.fetchOrigin(ctx, t.ref, header)
+	})
+}
+
+// fromPeer запрашивает превью у экземпляра, которому оно принадлежит. Возвращает false,
+// если превью нужно сделать здесь: оно принадлежит этому экземпляру, владелец недоступен,
+// исходник передан прямо в ссылке и скачивать его не нужно или превью сделано из изображения,
+// присланного клиентом, и есть только у экземпляра, который его принял.
+func (app *App) fromPeer(ctx context.Context, t transform, header http.Header) (*Preview, bool, error) {
+	if app.peers == nil || strings.HasPrefix(t.ref, source.DataPrefix) || strings.HasPrefix(t.ref, uploadPrefix) {
+		return nil, false, nil
+	}
+	owner, remote := app.peers.Owner(t.key)
+	if !remote {
+		return nil, false, nil
+	}
+	preview, err := app.peers.Fetch(ctx, owner, t.key, header)
+	var pErr *PreviewError
+	switch {
+	case err == nil:
+		app.logger.Info(fmt.Sprintf("preview get from peer %s", owner))
+		return preview, true, nil
+	case errors.As(err, &pErr):
+		return nil, true, err
+	case ctx.Err() != nil:
+		return nil, true, &PreviewError{http.StatusGatewayTimeout, "fail fetch data request", ctx.Err()}
+	}
+	app.logger.Warn(fmt.Sprintf("peer %s unavailable, make preview locally: %s", owner, err))
+	return nil, false, nil
+}
+
+// PreviewUpload делает превью по пути запроса /fill/{width}/{height} из изображения,
+// присланного клиентом. Превью кэшируется по хэшу содержимого, поэтому
+// повторная отправка того же изображения отдается из кэша.
+func (app *App) PreviewUpload(ctx context.Context, path string, data []byte) (*Preview, error) {
+	if contentType := http.DetectContentType(data); !strings.HasPrefix(contentType, "image/jpeg") {
+		return nil, &PreviewError{http.StatusUnsupportedMediaType, "fail fetch data request", fmt.Errorf("not a JPEG image")}
+	}
+	sum := sha256.Sum256(data)
+	ref := uploadPrefix + hex.EncodeToString(sum[:]) + ".jpg"
+	t, err := parseTransform(strings.TrimRight(path, "/") + "/" + ref)
+	if err != nil {
+		return nil, err
+	}
+	if t.ref != ref {
+		return nil, &PreviewError{http.StatusBadRequest, "not correct path", fmt.Errorf("source is not allowed in upload path")}
+	}
+	return app.preview(ctx, t, nil, func(context.Context) (fetched, int, error) {
+		return fetched{data: data, freshness: Fresh}, http.StatusOK, nil
+	})
+}
+
+// preview отдает превью из кэша или делает его из исходника, полученного load.
+func (app *App) preview(
+	ctx context.Context, t transform, header http.Header, load func(context.Context) (fetched, int, error),
+) (*Preview, error) {
+	preview, err := app.fromCache(ctx, t.key, t.ref, header)
+	if err != nil || preview != nil {
+		return preview, err
+	}
+
+	// загрузка не должна прерываться, если клиент, который ее начал, отключился:
+	// ее результат ждут и другие запросы
+	sharedCtx := context.WithoutCancel(ctx)
+	ch := app.previews.DoChan(t.key, func() (interface{}, error) {
+		return app.produce(sharedCtx, t, load)
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, &PreviewError{http.StatusGatewayTimeout, "fail fetch data request", ctx.Err()}
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		if res.Shared {
+			app.logger.Info("preview shared between concurrent requests")
+		}
+		return res.Val.(*Preview), nil
+	}
+}
+
+// fromCache отдает превью из кэша: из памяти, а если его там нет - с диска,
+// после чего превью переносится в память. Перед этим исходник перепроверяется,
+// и если он изменился, превью удаляется из кэша и делается заново (возвращается nil).
+func (app *App) fromCache(ctx context.Context, key, ref string, header http.Header) (*Preview, error) {
+	if _, ok := app.cache.Get(key); !ok {
+		app.hot.memory.record(false)
+		app.hot.disk.record(false)
+		return nil, nil
+	}
+	freshness, status, err := app.revalidate(ctx, ref, header)
+	if err != nil {
+		return nil, &PreviewError{status, "fail revalidate source", err}
+	}
+	// превью могло быть удалено при перепроверке, обращение к нему уже учтено
+	entry, ok := app.cache.Peek(key)
+	if !ok {
+		return nil, nil
+	}
+	stored := entry.file
+	app.files.touch(stored)
+	if data, ok := app.hot.get(key, stored); ok {
+		app.hot.memory.record(true)
+		app.logger.Info("image get from memory cache")
+		return &Preview{Data: data, FromCache: true, Freshness: freshness}, nil
+	}
+	app.hot.memory.record(false)
+
+	data, err := app.files.read(stored)
+	app.hot.disk.record(err == nil)
+	if err != nil {
+		app.logger.Error(err.Error())
+		app.logger.Info("image not found on disk")
+		return nil, nil
+	}
+	app.hot.promote(key, stored, data)
+	app.logger.Info("image get from cache")
+	return &Preview{Data: data, FromCache: true, Freshness: freshness}, nil
+}
+
+// produce получает исходник и делает из него превью.
+func (app *App) produce(
+	ctx context.Context, t transform, load func(context.Context) (fetched, int, error),
+) (*Preview, error) {
+	origin, status, err := load(ctx)
+	if err != nil {
+		return nil, &PreviewError{status, "fail fetch data request", err}
+	}
+	data, err := app.fill(origin.data, origin.digest, t.key, t.width, t.height, app.previewTTL(origin.maxAge))
+	if err != nil {
+		return nil, &PreviewError{http.StatusUnprocessableEntity, "fail fetch data", err}
+	}
+	return &Preview{Data: data, Freshness: origin.freshness}, nil
+}
+
+// previewTTL срок жизни превью в кэше. Если источник указал срок хранения в Cache-Control,
+// превью живет, пока его можно отдавать: срок свежести исходника и окно stale-if-error.
+// Иначе действует срок по-умолчанию.
+func (app *App) previewTTL(maxAge time.Duration) time.Duration {
+	if maxAge > 0 {
+		return maxAge + app.upstream.StaleIfError
+	}
+	return app.ttl
+}
+
+// fetchOrigin скачивает изображение из источника. Свежие исходники
+// берутся из кэша исходников, устаревшие отдаются сразу с фоновой перепроверкой
+// (в пределах окна stale-while-revalidate) или перепроверяются у источника условным запросом.
+// Если источник недоступен, в пределах окна stale-if-error используется устаревшая копия.
+// Изображения, присланные клиентом, не хранятся: если их превью нет в кэше, оно не найдено.
+func (app *App) fetchOrigin(ctx context.Context, ref string, header http.Header) (fetched, int, error) {
+	if strings.HasPrefix(ref, uploadPrefix) {
+		return fetched{}, http.StatusNotFound, fmt.Errorf("uploaded image is not cached: %s", ref)
+	}
+	now := time.Now()
+	cached, hasCached := app.origins.get(ref)
+	if hasCached {
+		switch freshness := app.staleness(ref, now); freshness {
+		case Fresh:
+			app.logger.Info(fmt.Sprintf("source get from origin cache: %s", ref))
+			return fetchedOf(cached, freshness), http.StatusOK, nil
+		case StaleWhileRevalidate:
+			app.refreshInBackground(ref, header)
+			return fetchedOf(cached, freshness), http.StatusOK, nil
+		}
+	}
+
+	object, status, err := app.fetchShared(ctx, ref, header, true)
+	if err != nil {
+		if hasCached && app.staleIfError(ref, status, now) {
+			app.logger.Warn(fmt.Sprintf("serve stale source %s: %s", ref, err))
+			return fetchedOf(cached, StaleIfError), http.StatusOK, nil
+		}
+		return fetched{}, status, err
+	}
+	return fetchedOf(object, Fresh), status, nil
+}
+
+func fetchedOf(object *source.Object, freshness string) fetched {
+	return fetched{data: object.Data, freshness: freshness, maxAge: object.MaxAge, digest: digestOf(object.Data)}
+}
+
+// revalidate перепроверяет у источника устаревший исходник перед отдачей превью из кэша
+// и возвращает, насколько свежим можно считать превью.
+// Если исходник не изменился (304 Not Modified), превью остаются в кэше,
+// если изменился - все превью из него удаляются из кэша и будут сделаны заново.
+func (app *App) revalidate(ctx context.Context, ref string, header http.Header) (string, int, error) {
+	now := time.Now()
+	switch freshness := app.staleness(ref, now); freshness {
+	case Fresh:
+		return freshness, http.StatusOK, nil
+	case StaleWhileRevalidate:
+		app.refreshInBackground(ref, header)
+		return freshness, http.StatusOK, nil
+	}
+
+	_, status, err := app.fetchShared(ctx, ref, header, false)
+	if err != nil {
+		if app.staleIfError(ref, status, now) {
+			app.logger.Warn(fmt.Sprintf("serve stale previews of %s: %s", ref, err))
+			return StaleIfError, http.StatusOK, nil
+		}
+		return "", status, err
+	}
+	return Fresh, http.StatusOK, nil
+}
+
+// fetchShared объединяет одновременные загрузки одного исходника в одну.
+// Перепроверки, которым байты исходника не нужны (needData false), объединяются отдельно от загрузок.
+func (app *App) fetchShared(ctx context.Context, ref string, header http.Header, needData bool) (*source.Object, int, error) {
+	// общая загрузка не прерывается, если отключился клиент, который ее начал
+	sharedCtx := context.WithoutCancel(ctx)
+	key := ref
+	if !needData {
+		key = "revalidate:" + ref
+	}
+	ch := app.fetches.DoChan(key, func() (interface{}, error) {
+		object, status, err := app.fetchSource(sharedCtx, ref, header, needData)
+		return fetchResult{object: object, status: status}, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, http.StatusGatewayTimeout, fmt.Errorf("waiting for upstream: %w", ctx.Err())
+	case res := <-ch:
+		result := res.Val.(fetchResult)
+		return result.object, result.status, res.Err
+	}
+}
+
+// fetchSource скачивает исходник и обновляет кэш исходников.
+// Если исходник есть в кэше, он перепроверяется по своим валидаторам,
+// и если не изменился (304), используется кэшированная копия.
+// Если байты исходника не нужны (needData false, перепроверка превью), а в кэше исходников
+// их уже нет, запрос отправляется с валидаторами из учета исходников, и на 304
+// возвращается nil: продлевается только срок свежести, превью остаются в кэше.
+func (app *App) fetchSource(ctx context.Context, ref string, header http.Header, needData bool) (*source.Object, int, error) {
+	cached, hasCached := app.origins.get(ref)
+	validators := cached
+	if !hasCached && !needData {
+		if state, ok := app.index.state(ref); ok && (state.ETag != "" || state.LastModified != "") {
+			validators = &source.Object{ETag: state.ETag, LastModified: state.LastModified}
+		}
+	}
+
+	src, rest := app.sources.Resolve(ref)
+	object, status, err := src.Fetch(ctx, source.Request{Ref: rest, Header: header, Cached: validators})
+	if err != nil {
+		return nil, status, err
+	}
+	if status == http.StatusNotModified {
+		if validators == nil {
+			return nil, http.StatusBadGateway, fmt.Errorf("unexpected 304 from upstream")
+		}
+		app.logger.Info(fmt.Sprintf("source not modified: %s", ref))
+		app.index.refresh(ref, object.Expires)
+		return cached, http.StatusOK, nil
+	}
+
+	if stale := app.index.update(ref, object); len(stale) > 0 {
+		app.logger.Info(fmt.Sprintf("source changed: %s, invalidate %d previews", ref, len(stale)))
+		for _, variant := range stale {
+			app.cache.Remove(variant)
+		}
+	}
+	app.origins.set(ref, object)
+	return object, status, nil
+}
+
+// transformKey приводит путь запроса /fill/{w}/{h}/{host}/{path} к нормализованному
+// ключу преобразования: размеры без ведущих нулей, хост в нижнем регистре,
+// без пустых сегментов пути. Одинаковые по смыслу запросы получают один ключ
+// и для кэша, и для объединения одновременных загрузок.
+// Data: URI берется как есть: регистр и все символы в нем значимы.
+func transformKey(path string) string {
+	parts := strings.Split(path, "/")
+	segments := make([]string, 0, len(parts))
+	isData := false
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		if len(segments) == 3 && strings.HasPrefix(part, source.DataPrefix) {
+			segments = append(segments, strings.Join(parts[i:], "/"))
+			isData = true
+			break
+		}
+		segments = append(segments, part)
+	}
+	if len(segments) < 3 {
+		return path
+	}
+	for i := 1; i <= 2; i++ {
+		if n, err := strconv.Atoi(segments[i]); err == nil {
+			segments[i] = strconv.Itoa(n)
+		}
+	}
+	if len(segments) > 3 && !isData {
+		segments[3] = strings.ToLower(segments[3])
+	}
+	return "/" + strings.Join(segments, "/")
+}
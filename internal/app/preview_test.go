@@ -0,0 +1,143 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformKey(t *testing.T) {
+	cases := map[string]string{
+		"/fill/300/200/Example.COM/img/beaver.jpg":      "/fill/300/200/example.com/img/beaver.jpg",
+		"/fill/0300/0200/example.com//img/beaver.jpg":   "/fill/300/200/example.com/img/beaver.jpg",
+		"/fill/300/200/data:image/jpeg;base64,Ab//Cd==": "/fill/300/200/data:image/jpeg;base64,Ab//Cd==",
+		"/fill/300": "/fill/300",
+	}
+	for path, key := range cases {
+		require.Equal(t, key, transformKey(path), path)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+
+	app := newTestApp(config.Config{
+		Cache:   config.CacheCfg{OriginMaxBytes: 1 << 20, TTL: time.Hour},
+		Storage: config.StorageCfg{Path: t.TempDir()},
+	})
+	path := "/fill/50/40/data:image/jpeg;base64," + base64.RawURLEncoding.EncodeToString(img)
+
+	preview, err := app.Preview(context.Background(), path, http.Header{})
+	require.NoError(t, err)
+	require.False(t, preview.FromCache)
+	require.Equal(t, Fresh, preview.Freshness)
+	require.NotEmpty(t, preview.Data)
+
+	entry, ok := app.cache.Get(transformKey(path))
+	require.True(t, ok)
+	require.Equal(t, int64(len(preview.Data)), entry.Size)
+	require.Equal(t, "image/jpeg", entry.ContentType)
+	require.Equal(t, previewFileName(transformKey(path)), entry.Path())
+	require.False(t, entry.Expires.IsZero())
+
+	cached, err := app.Preview(context.Background(), path, http.Header{})
+	require.NoError(t, err)
+	require.True(t, cached.FromCache)
+	require.Equal(t, preview.Data, cached.Data)
+
+	errorCases := []struct {
+		path    string
+		status  int
+		details string
+	}{
+		{"/fill/50/40", http.StatusBadRequest, "not correct path"},
+		{"/fill/0/40/example.com/beaver.jpg", http.StatusBadRequest, "fail fetch data"},
+		{"/fill/50/40/local/photos/beaver.jpg", http.StatusNotFound, "fail fetch data request"},
+	}
+	for _, tc := range errorCases {
+		_, err := app.Preview(context.Background(), tc.path, http.Header{})
+		var pErr *PreviewError
+		require.True(t, errors.As(err, &pErr), tc.path)
+		require.Equal(t, tc.status, pErr.Status, tc.path)
+		require.Equal(t, tc.details, pErr.Details, tc.path)
+	}
+}
+
+func TestPreviewUpload(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+
+	app := newTestApp(config.Config{Storage: config.StorageCfg{Path: t.TempDir()}})
+
+	preview, err := app.PreviewUpload(context.Background(), "/fill/50/40", img)
+	require.NoError(t, err)
+	require.False(t, preview.FromCache)
+
+	// то же изображение отдается из кэша по хэшу содержимого
+	cached, err := app.PreviewUpload(context.Background(), "/fill/050/40/", img)
+	require.NoError(t, err)
+	require.True(t, cached.FromCache)
+	require.Equal(t, preview.Data, cached.Data)
+
+	// превью загруженного изображения доступно по ссылке, пока оно в кэше
+	sum := sha256.Sum256(img)
+	ref := uploadPrefix + hex.EncodeToString(sum[:]) + ".jpg"
+	cached, err = app.Preview(context.Background(), "/fill/50/40/"+ref, http.Header{})
+	require.NoError(t, err)
+	require.True(t, cached.FromCache)
+	_, err = app.Preview(context.Background(), "/fill/60/40/"+ref, http.Header{})
+	var notFound *PreviewError
+	require.True(t, errors.As(err, &notFound))
+	require.Equal(t, http.StatusNotFound, notFound.Status)
+
+	errorCases := []struct {
+		path   string
+		data   []byte
+		status int
+	}{
+		{"/fill/50/40", []byte("not an image"), http.StatusUnsupportedMediaType},
+		{"/fill/50/40/example.com/beaver.jpg", img, http.StatusBadRequest},
+		{"/fill/50/0", img, http.StatusBadRequest},
+	}
+	for _, tc := range errorCases {
+		_, err := app.PreviewUpload(context.Background(), tc.path, tc.data)
+		var pErr *PreviewError
+		require.True(t, errors.As(err, &pErr), tc.path)
+		require.Equal(t, tc.status, pErr.Status, tc.path)
+	}
+}
+
+func TestPreviewFileName(t *testing.T) {
+	a := previewFileName("/fill/300/200/a.com/x/photo.jpg")
+	b := previewFileName("/fill/300/200/b.com/y/photo.jpg")
+	require.NotEqual(t, a, b)
+	require.Equal(t, a, previewFileName("/fill/300/200/a.com/x/photo.jpg"))
+
+	parts := strings.Split(filepath.ToSlash(a), "/")
+	require.Len(t, parts, 3)
+	require.Equal(t, parts[0], parts[2][0:2])
+	require.Equal(t, parts[1], parts[2][2:4])
+	require.Len(t, parts[2], 64+len(".jpg"))
+}
+
+func TestPreviewTTL(t *testing.T) {
+	app := newTestApp(config.Config{
+		Cache:    config.CacheCfg{TTL: 24 * time.Hour},
+		Upstream: config.UpstreamCfg{StaleIfError: time.Hour},
+	})
+	require.Equal(t, 24*time.Hour, app.previewTTL(0))
+	// срок из Cache-Control источника заменяет срок по-умолчанию
+	require.Equal(t, time.Hour+time.Minute, app.previewTTL(time.Minute))
+}
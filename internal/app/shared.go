@@ -0,0 +1,92 @@
+package app
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/cache"
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+)
+
+var errStaleShared = errors.New("shared preview is made from another version of the source")
+
+// ShareCache делит превью с другими экземплярами сервиса через общее хранилище remote:
+// превью, сделанное одним экземпляром, остальные берут из хранилища, а не делают заново.
+// Пока хранилище недоступно, используется только локальный кэш.
+// Вызывается после New до начала обработки запросов.
+func (app *App) ShareCache(remote cache.Remote, cfg config.RedisCfg) error {
+	local, ok := app.cache.(cache.Cache[string, Entry])
+	if !ok {
+		return errors.New("cache does not support sharing")
+	}
+	app.cache = cache.NewShared[Entry](local, remote, entryCodec{app}, cfg)
+	return nil
+}
+
+// entryCodec передает превью через общее хранилище: описание превью и байты файла.
+// Полученное из хранилища превью сохраняется на диск этого экземпляра,
+// а состояние его исходника - в учет исходников.
+type entryCodec struct {
+	app *App
+}
+
+// Encode: длина описания (4 байта), описание в json, байты превью.
+func (c entryCodec) Encode(key string, entry Entry) ([]byte, error) {
+	data, err := c.app.files.read(entry.file)
+	if err != nil {
+		return nil, err
+	}
+	meta := previewMeta{Key: key, Expires: entry.Expires}
+	if state, ok := c.app.index.state(sourceOf(key)); ok {
+		meta.Source = &state
+	}
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("can't encode preview meta: %w", err)
+	}
+	buf := make([]byte, 4, 4+len(metaBytes)+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(metaBytes)))
+	buf = append(buf, metaBytes...)
+	return append(buf, data...), nil
+}
+
+// Decode отбрасывает превью, сделанное не из той версии исходника, которая известна этому экземпляру.
+func (c entryCodec) Decode(key string, buf []byte) (Entry, error) {
+	if len(buf) < 4 || int(binary.BigEndian.Uint32(buf)) > len(buf)-4 {
+		return Entry{}, errors.New("shared preview is truncated")
+	}
+	metaLen := int(binary.BigEndian.Uint32(buf))
+	var meta previewMeta
+	if err := json.Unmarshal(buf[4:4+metaLen], &meta); err != nil {
+		return Entry{}, fmt.Errorf("can't decode preview meta: %w", err)
+	}
+	data := buf[4+metaLen:]
+	if meta.Key != key {
+		return Entry{}, fmt.Errorf("shared preview key mismatch: %s", meta.Key)
+	}
+
+	ref := sourceOf(key)
+	known, isKnown := c.app.index.state(ref)
+	if meta.Source != nil && isKnown && known.Digest != meta.Source.Digest {
+		return Entry{}, errStaleShared
+	}
+	stored, err := c.app.files.save(previewFileName(key), data, meta)
+	if err != nil {
+		return Entry{}, err
+	}
+	if meta.Source != nil {
+		if isKnown {
+			if !c.app.index.addVariant(ref, key, meta.Source.Digest) {
+				c.app.files.removeAsync(stored)
+				return Entry{}, errStaleShared
+			}
+		} else {
+			c.app.index.restore(ref, key, *meta.Source)
+		}
+	}
+	c.app.hot.promote(key, stored, data)
+	c.app.logger.Info(fmt.Sprintf("preview get from shared cache: %s", key))
+	return newEntry(stored, int64(len(data)), meta), nil
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/cache"
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/Ser9unin/ImagePreviewer/internal/redis"
+	"github.com/Ser9unin/ImagePreviewer/internal/redis/redistest"
+	"github.com/Ser9unin/ImagePreviewer/internal/source"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedCache(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+	var upstreamCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		upstreamCalls.Add(1)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(img)
+	}))
+	defer upstream.Close()
+
+	srv := redistest.NewServer()
+	defer srv.Close()
+	redisCfg := config.RedisCfg{Addr: srv.Addr(), Prefix: "previewer:", Timeout: time.Second, RetryInterval: time.Minute}
+	client, err := redis.NewClient(redisCfg)
+	require.NoError(t, err)
+	defer client.Close()
+
+	newApp := func() *App {
+		cfg := config.Config{
+			Upstream: config.UpstreamCfg{Timeout: 5 * time.Second, RetryAttempts: 1, DefaultTTL: time.Hour},
+			Cache:    config.CacheCfg{TTL: time.Hour},
+			Storage:  config.StorageCfg{Path: t.TempDir()},
+		}
+		app := New(cfg, cache.New[string, Entry](config.CacheCfg{Capacity: 10, TTL: time.Hour}), source.NewDefault(cfg, nopLogger{}), nopLogger{})
+		require.NoError(t, app.ShareCache(client, redisCfg))
+		return app
+	}
+	first, second := newApp(), newApp()
+
+	path := "/fill/50/40/" + strings.TrimPrefix(upstream.URL, "http://") + "/beaver.jpg"
+	key := transformKey(path)
+	made, err := first.Preview(context.Background(), path, http.Header{})
+	require.NoError(t, err)
+	require.False(t, made.FromCache)
+	require.Equal(t, []string{"previewer:" + key}, srv.Keys())
+	// превью только в общем хранилище не попадает в список превью экземпляра
+	page, total := second.Entries(0, 10)
+	require.Empty(t, page)
+	require.Zero(t, total)
+
+	// второй экземпляр берет превью из общего хранилища, не обращаясь к источнику
+	shared, err := second.Preview(context.Background(), path, http.Header{})
+	require.NoError(t, err)
+	require.True(t, shared.FromCache)
+	require.Equal(t, made.Data, shared.Data)
+	require.Equal(t, int32(1), upstreamCalls.Load())
+	entry, ok := second.cache.Peek(key)
+	require.True(t, ok)
+	require.FileExists(t, filepath.Join(second.files.root, entry.Path()))
+	require.Equal(t, int64(len(made.Data)), entry.Size)
+	require.False(t, entry.Expires.IsZero())
+	_, ok = second.index.expiry(sourceOf(key))
+	require.True(t, ok)
+	require.Equal(t, int64(1), second.cache.Stats().RemoteHits)
+	page, total = second.Entries(0, 10)
+	require.Len(t, page, 1)
+	require.Equal(t, 1, total)
+
+	// удаление на одном экземпляре удаляет превью из общего хранилища,
+	// в том числе превью, которых нет в кэше этого экземпляра
+	_, err = first.Preview(context.Background(), "/fill/70/40/"+sourceOf(key), http.Header{})
+	require.NoError(t, err)
+	other := "/fill/50/40/" + sourceOf(key) + "x"
+	_, err = first.Preview(context.Background(), other, http.Header{})
+	require.NoError(t, err)
+	require.Equal(t, 2, second.PurgeSource(upstream.URL+"/beaver.jpg"))
+	require.Equal(t, []string{"previewer:" + transformKey(other)}, srv.Keys())
+	require.Equal(t, 1, second.PurgePrefix(sourceOf(key)))
+	require.Empty(t, srv.Keys())
+
+	// превью из другой версии исходника, чем известная экземпляру, не принимается
+	_, err = first.Preview(context.Background(), "/fill/60/40/"+sourceOf(key), http.Header{})
+	require.NoError(t, err)
+	second.index.update(sourceOf(key), &source.Object{Data: []byte("changed")})
+	_, ok = second.cache.Get(transformKey("/fill/60/40/" + sourceOf(key)))
+	require.False(t, ok)
+
+	// при недоступном хранилище экземпляр делает превью сам
+	srv.Close()
+	third := newApp()
+	preview, err := third.Preview(context.Background(), path, http.Header{})
+	require.NoError(t, err)
+	require.False(t, preview.FromCache)
+	require.Positive(t, third.cache.Stats().RemoteErrors)
+}
@@ -0,0 +1,188 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/source"
+)
+
+// sourceInfo то, что известно об исходном изображении: валидаторы, срок свежести,
+// хэш содержимого и превью, сделанные из него.
+type sourceInfo struct {
+	etag         string
+	lastModified string
+	digest       [sha256.Size]byte
+	expires      time.Time
+	variants     map[string]struct{}
+}
+
+// sourceIndex учет исходников по нормализованной ссылке на исходник.
+type sourceIndex struct {
+	mu    sync.Mutex
+	items map[string]*sourceInfo
+}
+
+func newSourceIndex() *sourceIndex {
+	return &sourceIndex{items: make(map[string]*sourceInfo)}
+}
+
+// expiry возвращает срок свежести исходника.
+func (s *sourceIndex) expiry(key string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.items[key]
+	if !ok {
+		return time.Time{}, false
+	}
+	return info.expires, true
+}
+
+// addVariant связывает превью (ключ кэша) с исходником, из которого оно сделано.
+// digest - хэш содержимого исходника, из которого сделано превью. Если исходник
+// с тех пор изменился или забыт, превью не связывается и возвращается false.
+func (s *sourceIndex) addVariant(key, variant, digest string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.items[key]
+	if !ok || hex.EncodeToString(info.digest[:]) != digest {
+		return false
+	}
+	info.variants[variant] = struct{}{}
+	return true
+}
+
+// removeVariant забывает превью variant, покинувшее кэш. Исходник, у которого не осталось превью,
+// забывается, если inUse не сообщает, что он еще нужен (например, лежит в кэше исходников).
+func (s *sourceIndex) removeVariant(key, variant string, inUse func(key string) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.items[key]
+	if !ok {
+		return
+	}
+	if _, ok := info.variants[variant]; !ok {
+		return
+	}
+	delete(info.variants, variant)
+	if len(info.variants) == 0 && !inUse(key) {
+		delete(s.items, key)
+	}
+}
+
+// dropUnused забывает исходник, если из него не сделано ни одного превью в кэше,
+// например, когда исходник вытеснен из кэша исходников.
+func (s *sourceIndex) dropUnused(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if info, ok := s.items[key]; ok && len(info.variants) == 0 {
+		delete(s.items, key)
+	}
+}
+
+// refresh продлевает срок свежести исходника после ответа 304 Not Modified.
+func (s *sourceIndex) refresh(key string, expires time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if info, ok := s.items[key]; ok {
+		info.expires = expires
+	}
+}
+
+// update запоминает скачанный исходник. Если содержимое изменилось,
+// возвращает ключи превью, сделанных из прежней версии, - они больше не действительны.
+func (s *sourceIndex) update(key string, origin *source.Object) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	digest := sha256.Sum256(origin.Data)
+	info, ok := s.items[key]
+	if !ok {
+		info = &sourceInfo{variants: make(map[string]struct{})}
+		s.items[key] = info
+	}
+
+	var stale []string
+	if ok && info.digest != digest {
+		stale = make([]string, 0, len(info.variants))
+		for variant := range info.variants {
+			stale = append(stale, variant)
+		}
+		info.variants = make(map[string]struct{})
+	}
+
+	info.etag = origin.ETag
+	info.lastModified = origin.LastModified
+	info.digest = digest
+	info.expires = origin.Expires
+	return stale
+}
+
+// removeMatching забывает исходники, ключи которых подходят под match.
+func (s *sourceIndex) removeMatching(match func(key string) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.items {
+		if match(key) {
+			delete(s.items, key)
+		}
+	}
+}
+
+// sourceState сохраняемая вместе с превью часть sourceInfo,
+// по ней после перезапуска восстанавливается учет исходника.
+type sourceState struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	Digest       string    `json:"digest"`
+	Expires      time.Time `json:"expires"`
+}
+
+// digestOf хэш содержимого исходника в том виде, в котором он хранится в sourceState.
+func digestOf(data []byte) string {
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
+
+// state возвращает сохраняемое состояние исходника.
+func (s *sourceIndex) state(key string) (sourceState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.items[key]
+	if !ok {
+		return sourceState{}, false
+	}
+	return sourceState{
+		ETag:         info.etag,
+		LastModified: info.lastModified,
+		Digest:       hex.EncodeToString(info.digest[:]),
+		Expires:      info.expires,
+	}, true
+}
+
+// restore восстанавливает учет исходника по сохраненному состоянию
+// и связывает с ним превью variant.
+func (s *sourceIndex) restore(key, variant string, state sourceState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.items[key]
+	if !ok {
+		info = &sourceInfo{variants: make(map[string]struct{})}
+		s.items[key] = info
+	}
+	info.etag = state.ETag
+	info.lastModified = state.LastModified
+	hex.Decode(info.digest[:], []byte(state.Digest)) //nolint:errcheck
+	info.expires = state.Expires
+	info.variants[variant] = struct{}{}
+}
@@ -0,0 +1,228 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/cache"
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/Ser9unin/ImagePreviewer/internal/source"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevalidation(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+
+	var version, full, notModified atomic.Int32
+	version.Store(1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := fmt.Sprintf(`"v%d"`, version.Load())
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "max-age=0")
+		if r.Header.Get("If-None-Match") == etag {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("Content-Type", "image/jpeg")
+		if version.Load() > 1 {
+			w.Write(append(append([]byte{}, img...), 0))
+			return
+		}
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	c := cache.New[string, Entry](config.CacheCfg{Capacity: 10})
+	cfg := config.Config{
+		Cache:    config.CacheCfg{OriginMaxBytes: 1 << 20},
+		Upstream: config.UpstreamCfg{Timeout: 5 * time.Second, RetryAttempts: 1},
+	}
+	app := New(cfg, c, source.NewDefault(cfg, nopLogger{}), nopLogger{})
+
+	ref := strings.TrimPrefix(srv.URL, "http://") + "/beaver_cute.jpg"
+	variant := "/fill/10/10/" + ref
+	fetch := func() {
+		_, status, err := app.fetchOrigin(context.Background(), ref, http.Header{})
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, status)
+	}
+
+	fetch()
+	c.Set(variant, Entry{file: storedFile{path: previewFileName(variant)}})
+	app.index.addVariant(sourceOf(variant), variant, digestOf(img))
+
+	// max-age=0: исходник сразу устаревает и перепроверяется, но не изменился
+	freshness, status, err := app.revalidate(context.Background(), ref, http.Header{})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, Fresh, freshness)
+	require.Equal(t, int32(1), full.Load())
+	require.Equal(t, int32(1), notModified.Load())
+	_, ok := c.Get(variant)
+	require.True(t, ok)
+
+	// исходник изменился: превью из прежней версии удаляются
+	version.Store(2)
+	_, _, err = app.revalidate(context.Background(), ref, http.Header{})
+	require.NoError(t, err)
+	require.Equal(t, int32(2), full.Load())
+	_, ok = c.Get(variant)
+	require.False(t, ok)
+}
+
+func TestRevalidationWithoutOrigin(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+
+	var full, notModified atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=0")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	c := cache.New[string, Entry](config.CacheCfg{Capacity: 10})
+	cfg := config.Config{
+		Cache:    config.CacheCfg{OriginMaxBytes: 1 << 20},
+		Upstream: config.UpstreamCfg{Timeout: 5 * time.Second, RetryAttempts: 1},
+	}
+	app := New(cfg, c, source.NewDefault(cfg, nopLogger{}), nopLogger{})
+
+	ref := strings.TrimPrefix(srv.URL, "http://") + "/beaver_cute.jpg"
+	variant := "/fill/10/10/" + ref
+	_, _, err = app.fetchOrigin(context.Background(), ref, http.Header{})
+	require.NoError(t, err)
+	c.Set(variant, Entry{file: storedFile{path: previewFileName(variant)}})
+	require.True(t, app.index.addVariant(ref, variant, digestOf(img)))
+
+	// исходник вытеснен из кэша исходников (или не пережил перезапуск),
+	// но превью перепроверяется условным запросом по валидаторам из учета исходников
+	app.origins.removeMatching(func(key string) bool { return key == ref })
+	freshness, status, err := app.revalidate(context.Background(), ref, http.Header{})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, Fresh, freshness)
+	require.Equal(t, int32(1), full.Load())
+	require.Equal(t, int32(1), notModified.Load())
+	_, ok := c.Peek(variant)
+	require.True(t, ok)
+
+	// для нового превью нужны байты исходника, он скачивается целиком
+	origin, status, err := app.fetchOrigin(context.Background(), ref, http.Header{})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, img, origin.data)
+	require.Equal(t, int32(2), full.Load())
+}
+
+func TestSourceIndexForgetsUnused(t *testing.T) {
+	origin := &source.Object{Data: []byte("image"), ETag: `"v1"`}
+	digest := digestOf(origin.Data)
+
+	t.Run("drops source without variants", func(t *testing.T) {
+		s := newSourceIndex()
+		s.update("a", origin)
+		s.addVariant("a", "/fill/1/1/a", digest)
+		s.addVariant("a", "/fill/2/2/a", digest)
+		notCached := func(string) bool { return false }
+
+		s.removeVariant("a", "/fill/1/1/a", notCached)
+		_, ok := s.state("a")
+		require.True(t, ok)
+
+		s.removeVariant("a", "/fill/2/2/a", notCached)
+		_, ok = s.state("a")
+		require.False(t, ok)
+	})
+
+	t.Run("keeps source in origin cache", func(t *testing.T) {
+		s := newSourceIndex()
+		s.update("a", origin)
+		s.addVariant("a", "/fill/1/1/a", digest)
+
+		s.removeVariant("a", "/fill/1/1/a", func(string) bool { return true })
+		_, ok := s.state("a")
+		require.True(t, ok)
+
+		// исходник вытеснен из кэша исходников, превью из него не осталось
+		s.dropUnused("a")
+		_, ok = s.state("a")
+		require.False(t, ok)
+	})
+
+	t.Run("keeps source with variants", func(t *testing.T) {
+		s := newSourceIndex()
+		s.update("a", origin)
+		s.addVariant("a", "/fill/1/1/a", digest)
+
+		s.dropUnused("a")
+		_, ok := s.state("a")
+		require.True(t, ok)
+	})
+}
+
+func TestEvictedVariantsLeaveIndex(t *testing.T) {
+	c := cache.New[string, Entry](config.CacheCfg{Capacity: 1})
+	cfg := config.Config{Cache: config.CacheCfg{OriginMaxBytes: 8}}
+	app := New(cfg, c, source.NewDefault(cfg, nopLogger{}), nopLogger{})
+
+	origin := &source.Object{Data: []byte("image")}
+	for _, ref := range []string{"example.com/a.jpg", "example.com/b.jpg"} {
+		app.index.update(ref, origin)
+		app.origins.set(ref, origin)
+		variant := "/fill/10/10/" + ref
+		c.Set(variant, Entry{file: storedFile{path: previewFileName(variant)}})
+		app.index.addVariant(ref, variant, digestOf(origin.Data))
+	}
+
+	// исходник a вытеснен из кэша исходников, а его единственное превью из кэша превью
+	_, ok := app.index.state("example.com/a.jpg")
+	require.False(t, ok)
+	_, ok = app.index.state("example.com/b.jpg")
+	require.True(t, ok)
+}
+
+func TestFillSkipsChangedSource(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+
+	app := newTestApp(config.Config{Storage: config.StorageCfg{Path: t.TempDir()}})
+	ref := "example.com/beaver_cute.jpg"
+	key := "/fill/50/40/" + ref
+
+	// пока превью делалось из img, исходник обновился
+	app.index.update(ref, &source.Object{Data: append(append([]byte{}, img...), 0)})
+	data, err := app.fill(img, digestOf(img), key, 50, 40, 0)
+	require.NoError(t, err)
+	require.NotEmpty(t, data)
+	_, ok := app.cache.Peek(key)
+	require.False(t, ok)
+
+	app.index.update(ref, &source.Object{Data: img})
+	_, err = app.fill(img, digestOf(img), key, 50, 40, 0)
+	require.NoError(t, err)
+	_, ok = app.cache.Peek(key)
+	require.True(t, ok)
+
+	// превью из старой версии удаляется при следующем изменении исходника
+	stale := app.index.update(ref, &source.Object{Data: append(append([]byte{}, img...), 1)})
+	require.Equal(t, []string{key}, stale)
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Степень свежести отдаваемого превью, сообщается клиенту в заголовке Freshness.
+const (
+	Fresh = "fresh"
+	// StaleWhileRevalidate исходник устарел, превью отдано сразу, исходник перепроверяется в фоне.
+	StaleWhileRevalidate = "stale-while-revalidate"
+	// StaleIfError исходник устарел и не перепроверен из-за недоступности источника.
+	StaleIfError = "stale-if-error"
+
+	// mustRevalidate исходник устарел, и перед отдачей его нужно перепроверить.
+	mustRevalidate = "must-revalidate"
+)
+
+// staleness определяет, можно ли использовать исходник key без обращения к источнику.
+func (app *App) staleness(key string, now time.Time) string {
+	expires, ok := app.index.expiry(key)
+	switch {
+	case !ok || now.Before(expires):
+		return Fresh
+	case now.Before(expires.Add(app.upstream.StaleWhileRevalidate)):
+		return StaleWhileRevalidate
+	default:
+		return mustRevalidate
+	}
+}
+
+// staleIfError сообщает, что при сбое источника (status 5xx) еще можно отдать устаревшую копию.
+func (app *App) staleIfError(key string, status int, now time.Time) bool {
+	if status < http.StatusInternalServerError {
+		return false
+	}
+	expires, ok := app.index.expiry(key)
+	return ok && now.Before(expires.Add(app.upstream.StaleIfError))
+}
+
+// refreshInBackground перепроверяет исходник в фоне, не задерживая ответ клиенту.
+// Одновременные перепроверки одного исходника объединяются в fetchShared.
+func (app *App) refreshInBackground(ref string, header http.Header) {
+	header = header.Clone()
+	go func() {
+		if _, _, err := app.fetchShared(context.Background(), ref, header, false); err != nil {
+			app.logger.Warn(fmt.Sprintf("background revalidation of %s failed: %s", ref, err))
+		}
+	}()
+}
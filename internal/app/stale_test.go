@@ -0,0 +1,97 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaleServing(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+
+	var down atomic.Bool
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(img)
+	}))
+	defer srv.Close()
+
+	newApp := func(upstream config.UpstreamCfg) *App {
+		upstream.Timeout = 5 * time.Second
+		upstream.RetryAttempts = 1
+		return newTestApp(config.Config{
+			Cache:    config.CacheCfg{OriginMaxBytes: 1 << 20},
+			Upstream: upstream,
+		})
+	}
+	ref := strings.TrimPrefix(srv.URL, "http://") + "/beaver_cute.jpg"
+	fetch := func(app *App) (fetched, int, error) {
+		return app.fetchOrigin(context.Background(), ref, http.Header{})
+	}
+
+	t.Run("stale-while-revalidate", func(t *testing.T) {
+		down.Store(false)
+		requests.Store(0)
+		app := newApp(config.UpstreamCfg{StaleWhileRevalidate: time.Minute})
+
+		origin, _, err := fetch(app)
+		require.NoError(t, err)
+		require.Equal(t, Fresh, origin.freshness)
+
+		origin, status, err := fetch(app)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, StaleWhileRevalidate, origin.freshness)
+		require.Len(t, origin.data, len(img))
+
+		// фоновая перепроверка
+		require.Eventually(t, func() bool { return requests.Load() == 2 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("stale-if-error", func(t *testing.T) {
+		down.Store(false)
+		app := newApp(config.UpstreamCfg{StaleIfError: time.Minute})
+
+		_, _, err := fetch(app)
+		require.NoError(t, err)
+
+		down.Store(true)
+		origin, status, err := fetch(app)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, StaleIfError, origin.freshness)
+
+		freshness, _, err := app.revalidate(context.Background(), ref, http.Header{})
+		require.NoError(t, err)
+		require.Equal(t, StaleIfError, freshness)
+	})
+
+	t.Run("error without stale window", func(t *testing.T) {
+		down.Store(false)
+		app := newApp(config.UpstreamCfg{})
+
+		_, _, err := fetch(app)
+		require.NoError(t, err)
+
+		down.Store(true)
+		_, status, err := fetch(app)
+		require.Error(t, err)
+		require.Equal(t, http.StatusBadGateway, status)
+	})
+}
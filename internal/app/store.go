@@ -0,0 +1,439 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tempPrefix префикс временных файлов, в которые превью пишется до переименования в итоговое имя.
+const tempPrefix = ".tmp-"
+
+// storeStripes число блокировок, между которыми распределяются файлы превью.
+const storeStripes = 64
+
+// metaExt расширение файла с описанием превью, который лежит рядом с ним.
+const metaExt = ".json"
+
+// touchInterval как часто обращение к превью отмечается в его описании.
+const touchInterval = time.Minute
+
+// previewMeta описание превью, по которому после перезапуска восстанавливается кэш.
+type previewMeta struct {
+	// Key ключ преобразования, под которым превью хранится в кэше.
+	Key string `json:"key"`
+	// Source состояние исходника, из которого сделано превью (нет у присланных изображений).
+	Source *sourceState `json:"source,omitempty"`
+	// Expires момент устаревания превью в кэше, нулевое значение - без срока.
+	Expires time.Time `json:"expires"`
+	// AccessedAt момент последнего обращения к превью с точностью до touchInterval,
+	// по нему при запуске восстанавливается порядок вытеснения.
+	AccessedAt time.Time `json:"accessedAt,omitempty"`
+}
+
+// storedEntry превью, найденное на диске при запуске.
+type storedEntry struct {
+	file       storedFile
+	meta       previewMeta
+	size       int64
+	accessedAt time.Time
+}
+
+// storedFile файл превью, значение в кэше. version отличает повторную запись
+// того же файла от прежней.
+type storedFile struct {
+	path    string
+	version uint64
+}
+
+// fileStore превью на диске в каталоге root. Запись и удаление одного файла
+// выполняются под его блокировкой, а удаление вытесненного из кэша превью
+// пропускается, если файл с тех пор был записан заново.
+type fileStore struct {
+	root    string
+	logger  Logger
+	stripes [storeStripes]sync.Mutex
+
+	mu       sync.Mutex
+	versions map[string]uint64
+	next     uint64
+	// touched момент обращения к файлу, последний раз записанный в его описание.
+	touched map[string]time.Time
+	// closed после close файлы удаляются сразу, а обращения не записываются.
+	closed bool
+
+	// removals фоновые удаления, которые еще не завершились.
+	removals sync.WaitGroup
+	// touches фоновые записи обращений, которые еще не завершились.
+	touches sync.WaitGroup
+}
+
+func newFileStore(root string, logger Logger) *fileStore {
+	return &fileStore{
+		root:     root,
+		logger:   logger,
+		versions: make(map[string]uint64),
+		touched:  make(map[string]time.Time),
+	}
+}
+
+func (s *fileStore) stripe(name string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return &s.stripes[h.Sum32()%storeStripes]
+}
+
+// save записывает файл name (путь относительно root) и его описание,
+// запись считается обращением к превью.
+func (s *fileStore) save(name string, data []byte, meta previewMeta) (storedFile, error) {
+	lock := s.stripe(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	filePath := filepath.Join(s.root, name)
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		return storedFile{}, fmt.Errorf("ошибка создания папки: %w", err)
+	}
+	meta.AccessedAt = time.Now()
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return storedFile{}, fmt.Errorf("can't encode preview meta: %w", err)
+	}
+	// описание пишется после превью: описание без превью при запуске отбрасывается
+	if err := saveFileOnDisk(data, filePath); err != nil {
+		return storedFile{}, err
+	}
+	if err := saveFileOnDisk(metaBytes, metaPath(filePath)); err != nil {
+		return storedFile{}, err
+	}
+
+	return s.track(name, meta.AccessedAt), nil
+}
+
+// track присваивает файлу name новую версию, accessedAt - обращение, записанное в его описание.
+func (s *fileStore) track(name string, accessedAt time.Time) storedFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	s.versions[name] = s.next
+	s.touched[name] = accessedAt
+	return storedFile{path: name, version: s.next}
+}
+
+// touch отмечает обращение к превью в его описании, не чаще touchInterval.
+// Описание переписывается в фоне, если файл с тех пор не был записан заново или удален.
+func (s *fileStore) touch(file storedFile) {
+	now := time.Now()
+	s.mu.Lock()
+	if s.closed || s.versions[file.path] != file.version || now.Sub(s.touched[file.path]) < touchInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.touched[file.path] = now
+	s.touches.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.touches.Done()
+
+		lock := s.stripe(file.path)
+		lock.Lock()
+		defer lock.Unlock()
+
+		s.mu.Lock()
+		current := s.versions[file.path] == file.version
+		s.mu.Unlock()
+		if !current {
+			return
+		}
+		if err := s.writeAccess(metaPath(filepath.Join(s.root, file.path)), now); err != nil {
+			s.logger.Warn(fmt.Sprintf("failed to touch preview %s: %s", file.path, err))
+		}
+	}()
+}
+
+// writeAccess записывает в описание metaFile момент обращения к превью.
+func (s *fileStore) writeAccess(metaFile string, accessedAt time.Time) error {
+	data, err := os.ReadFile(metaFile)
+	if err != nil {
+		return err
+	}
+	var meta previewMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return fmt.Errorf("broken meta: %w", err)
+	}
+	meta.AccessedAt = accessedAt
+	if data, err = json.Marshal(meta); err != nil {
+		return fmt.Errorf("can't encode preview meta: %w", err)
+	}
+	return saveFileOnDisk(data, metaFile)
+}
+
+// load находит сохраненные превью, отсортированные от давно использованных к недавним
+// по моменту обращения из описания (у описаний без него - по времени записи файла).
+// Превью без описания и описания без превью удаляются. Рассматриваются только файлы
+// с именами, которые дает previewFileName (ab/cd/abcd....jpg), остальные файлы каталога
+// не трогаются: STORAGE_PATH мог по ошибке указать на каталог с чужими данными.
+func (s *fileStore) load() ([]storedEntry, error) {
+	var entries []storedEntry
+	described := make(map[string]bool)
+	var previews []string
+	foreign := 0
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == s.root {
+			return err
+		}
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(rel, string(filepath.Separator))
+		if d.IsDir() {
+			if len(parts) > 2 || !isHex(d.Name(), 2) {
+				foreign++
+				return fs.SkipDir
+			}
+			return nil
+		}
+		ext := filepath.Ext(path)
+		if len(parts) != 3 || (ext != metaExt && ext != ".jpg") ||
+			!isPreviewName(parts[0], parts[1], strings.TrimSuffix(parts[2], ext)) {
+			if !strings.HasPrefix(d.Name(), tempPrefix) {
+				foreign++
+			}
+			return nil
+		}
+		if ext != metaExt {
+			previews = append(previews, path)
+			return nil
+		}
+
+		entry, err := s.loadEntry(path)
+		if err != nil {
+			s.logger.Warn(fmt.Sprintf("drop stored preview %s: %s", path, err))
+			os.Remove(path)
+			return nil
+		}
+		described[filepath.Join(s.root, entry.file.path)] = true
+		entries = append(entries, entry)
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if foreign > 0 {
+		s.logger.Warn(fmt.Sprintf("storage %s: skipped %d files and directories not created by the service", s.root, foreign))
+	}
+
+	for _, path := range previews {
+		if !described[path] {
+			s.logger.Warn(fmt.Sprintf("drop stored preview %s: no meta", path))
+			os.Remove(path)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].accessedAt.Before(entries[j].accessedAt)
+	})
+	for i := range entries {
+		entries[i].file = s.track(entries[i].file.path, entries[i].meta.AccessedAt)
+	}
+	return entries, nil
+}
+
+// isPreviewName сообщает, что файл name в каталоге dir1/dir2 назван так, как его называет previewFileName.
+func isPreviewName(dir1, dir2, name string) bool {
+	return isHex(name, sha256.Size*2) && name[0:2] == dir1 && name[2:4] == dir2
+}
+
+// isHex сообщает, что s - n шестнадцатеричных цифр в нижнем регистре.
+func isHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *fileStore) loadEntry(metaFile string) (storedEntry, error) {
+	data, err := os.ReadFile(metaFile)
+	if err != nil {
+		return storedEntry{}, err
+	}
+	var meta previewMeta
+	if err := json.Unmarshal(data, &meta); err != nil || meta.Key == "" {
+		return storedEntry{}, fmt.Errorf("broken meta: %w", err)
+	}
+	previewFile := strings.TrimSuffix(metaFile, metaExt) + ".jpg"
+	info, err := os.Stat(previewFile)
+	if err != nil {
+		return storedEntry{}, err
+	}
+	name, err := filepath.Rel(s.root, previewFile)
+	if err != nil {
+		return storedEntry{}, err
+	}
+	accessedAt := meta.AccessedAt
+	if accessedAt.IsZero() {
+		accessedAt = info.ModTime()
+	}
+	return storedEntry{
+		file:       storedFile{path: name},
+		meta:       meta,
+		size:       info.Size(),
+		accessedAt: accessedAt,
+	}, nil
+}
+
+func (s *fileStore) read(file storedFile) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.root, file.path))
+}
+
+// removeAsync удаляет файл в фоне, если он не был записан заново после file.
+// Читатель, который уже открыл файл, дочитает его; тот, кто не успел, получит ошибку
+// и сделает превью заново.
+// После close файл удаляется сразу, чтобы удаление не потерялось при выходе.
+func (s *fileStore) removeAsync(file storedFile) {
+	s.mu.Lock()
+	closed := s.closed
+	if !closed {
+		s.removals.Add(1)
+	}
+	s.mu.Unlock()
+	if closed {
+		s.remove(file)
+		return
+	}
+	go func() {
+		defer s.removals.Done()
+		s.remove(file)
+	}()
+}
+
+// remove удаляет файл и его описание, если он не был записан заново после file.
+func (s *fileStore) remove(file storedFile) {
+	lock := s.stripe(file.path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	s.mu.Lock()
+	current := s.versions[file.path] == file.version
+	if current {
+		delete(s.versions, file.path)
+		delete(s.touched, file.path)
+	}
+	s.mu.Unlock()
+	if !current {
+		return
+	}
+
+	filePath := filepath.Join(s.root, file.path)
+	for _, path := range []string{filePath, metaPath(filePath)} {
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			s.logger.Error(fmt.Sprintf("failed to remove evicted file %s: %s", path, err))
+		}
+	}
+}
+
+// close дожидается фоновых удалений и записей обращений. Иначе прерванное при выходе
+// удаление оставит на диске превью, которое при следующем запуске вернется в кэш.
+func (s *fileStore) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.touches.Wait()
+	s.removals.Wait()
+}
+
+// metaPath путь описания превью.
+func metaPath(previewPath string) string {
+	return strings.TrimSuffix(previewPath, filepath.Ext(previewPath)) + metaExt
+}
+
+// saveFileOnDisk атомарно записывает файл: сначала во временный файл в том же каталоге,
+// затем fsync и переименование в итоговое имя. Читатель видит либо прежний файл, либо
+// новый целиком, а после сбоя на диске не остается обрезанных превью.
+func saveFileOnDisk(fileBytes []byte, filename string) (err error) {
+	dir := filepath.Dir(filename)
+	file, err := os.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("can't create file: %w", err)
+	}
+	tempName := file.Name()
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(tempName)
+		}
+	}()
+
+	// записываем jpeg с новыми размерами
+	if _, err = file.Write(fileBytes); err != nil {
+		return fmt.Errorf("can't write file: %w", err)
+	}
+	if err = file.Chmod(0o644); err != nil {
+		return fmt.Errorf("can't write file: %w", err)
+	}
+	if err = file.Sync(); err != nil {
+		return fmt.Errorf("can't sync file: %w", err)
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("can't close file: %w", err)
+	}
+	if err = os.Rename(tempName, filename); err != nil {
+		return fmt.Errorf("can't rename file: %w", err)
+	}
+	// переименование сохраняется на диске только после fsync каталога
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("can't sync dir: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("can't sync dir: %w", err)
+	}
+	return nil
+}
+
+// removeTempFiles удаляет временные файлы, оставшиеся от записей, прерванных сбоем.
+// Возвращает число удаленных файлов.
+func removeTempFiles(root string) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasPrefix(d.Name(), tempPrefix) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return removed, nil
+	}
+	return removed, err
+}
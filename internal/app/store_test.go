@@ -0,0 +1,285 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/cache"
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/Ser9unin/ImagePreviewer/internal/source"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveFileOnDisk(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "preview.jpg")
+
+	require.NoError(t, saveFileOnDisk([]byte("first"), path))
+	require.NoError(t, saveFileOnDisk([]byte("second"), path))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "second", string(data))
+
+	// временных файлов не остается
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	require.Error(t, saveFileOnDisk([]byte("data"), filepath.Join(dir, "missing", "preview.jpg")))
+}
+
+func TestRemoveTempFiles(t *testing.T) {
+	root := t.TempDir()
+	shard := filepath.Join(root, "ab", "cd")
+	require.NoError(t, os.MkdirAll(shard, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(shard, "abcd.jpg"), []byte("preview"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(shard, tempPrefix+"123"), []byte("half"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, tempPrefix+"456"), []byte("half"), 0o600))
+
+	removed, err := removeTempFiles(root)
+	require.NoError(t, err)
+	require.Equal(t, 2, removed)
+	require.FileExists(t, filepath.Join(shard, "abcd.jpg"))
+	require.NoFileExists(t, filepath.Join(shard, tempPrefix+"123"))
+
+	removed, err = removeTempFiles(filepath.Join(root, "missing"))
+	require.NoError(t, err)
+	require.Zero(t, removed)
+}
+
+func TestEvictedFilesRemoved(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+
+	storagePath := t.TempDir()
+	app := New(config.Config{Storage: config.StorageCfg{Path: storagePath}}, cache.New[string, Entry](config.CacheCfg{Capacity: 1}), source.NewRegistry(nil), nopLogger{})
+	first, err := app.PreviewUpload(context.Background(), "/fill/50/40", img)
+	require.NoError(t, err)
+	sum := sha256.Sum256(img)
+	firstFile := filepath.Join(storagePath, previewFileName("/fill/50/40/"+uploadPrefix+hex.EncodeToString(sum[:])+".jpg"))
+	require.FileExists(t, firstFile)
+
+	// второе превью вытесняет первое, его файл удаляется
+	_, err = app.PreviewUpload(context.Background(), "/fill/60/40", img)
+	require.NoError(t, err)
+	app.files.removals.Wait()
+	require.NoFileExists(t, firstFile)
+
+	// превью делается заново и снова сохраняется
+	again, err := app.PreviewUpload(context.Background(), "/fill/50/40", img)
+	require.NoError(t, err)
+	require.False(t, again.FromCache)
+	require.Equal(t, first.Data, again.Data)
+	app.files.removals.Wait()
+	require.FileExists(t, firstFile)
+
+	// после Close удаление выполняется сразу: при выходе оно не прервется
+	app.Close()
+	require.True(t, app.Delete(transformKey("/fill/50/40/"+uploadPrefix+hex.EncodeToString(sum[:])+".jpg")))
+	require.NoFileExists(t, firstFile)
+	require.NoFileExists(t, metaPath(firstFile))
+}
+
+func TestCloseWaitsForRemovals(t *testing.T) {
+	store := newFileStore(t.TempDir(), nopLogger{})
+	files := make([]storedFile, 20)
+	for i := range files {
+		file, err := store.save(fmt.Sprintf("ab/cd/%d.jpg", i), []byte("preview"), previewMeta{Key: "k"})
+		require.NoError(t, err)
+		files[i] = file
+	}
+	for _, file := range files {
+		store.removeAsync(file)
+	}
+	store.close()
+	for _, file := range files {
+		require.NoFileExists(t, filepath.Join(store.root, file.path))
+	}
+}
+
+func TestFileStoreSkipsRewrittenFiles(t *testing.T) {
+	store := newFileStore(t.TempDir(), nopLogger{})
+
+	old, err := store.save("ab/cd/abcd.jpg", []byte("old"), previewMeta{Key: "/fill/1/1/a.com/a.jpg"})
+	require.NoError(t, err)
+	current, err := store.save("ab/cd/abcd.jpg", []byte("new"), previewMeta{Key: "/fill/1/1/a.com/a.jpg"})
+	require.NoError(t, err)
+
+	// удаление устаревшей версии не трогает перезаписанный файл
+	store.removeAsync(old)
+	store.removals.Wait()
+	data, err := store.read(current)
+	require.NoError(t, err)
+	require.Equal(t, "new", string(data))
+
+	store.removeAsync(current)
+	store.removals.Wait()
+	_, err = store.read(current)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestRestoreCache(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+	root := t.TempDir()
+	cfg := config.Config{Storage: config.StorageCfg{Path: root}}
+	newApp := func(capacity int) *App {
+		return New(cfg, cache.New[string, Entry](config.CacheCfg{Capacity: capacity}), source.NewDefault(cfg, nopLogger{}), nopLogger{})
+	}
+
+	first := newApp(10)
+	paths := []string{"/fill/50/40", "/fill/60/40", "/fill/70/40"}
+	files := make([]string, len(paths))
+	sum := sha256.Sum256(img)
+	for i, path := range paths {
+		_, err := first.PreviewUpload(context.Background(), path, img)
+		require.NoError(t, err)
+		files[i] = filepath.Join(root, previewFileName(path+"/"+uploadPrefix+hex.EncodeToString(sum[:])+".jpg"))
+	}
+	dataPath := "/fill/50/40/data:image/jpeg;base64," + base64.RawURLEncoding.EncodeToString(img)
+	_, err = first.Preview(context.Background(), dataPath, http.Header{})
+	require.NoError(t, err)
+
+	// порядок обращений: 70, 50, 60 (к превью из data: обращались последним),
+	// время чтения файлов при этом не учитывается
+	base := time.Now().Add(-time.Hour)
+	for i, file := range []string{files[2], files[0], files[1]} {
+		at := base.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, first.files.writeAccess(metaPath(file), at))
+		require.NoError(t, os.Chtimes(file, time.Now(), time.Now()))
+	}
+	// превью без описания не восстанавливается и удаляется
+	orphan := filepath.Join(root, previewFileName("/fill/1/1/orphan.jpg"))
+	require.NoError(t, os.MkdirAll(filepath.Dir(orphan), 0o755))
+	require.NoError(t, os.WriteFile(orphan, []byte("preview"), 0o600))
+
+	// чужие файлы в каталоге превью не трогаются
+	foreign := []string{
+		filepath.Join(root, "notes.txt"),
+		filepath.Join(root, "docs", "readme.jpg"),
+		filepath.Join(root, "ab", "cd", "photo.jpg"),
+		filepath.Join(root, "ab", "cd", "ef", previewFileName("/fill/2/2/x.jpg")),
+	}
+	for _, file := range foreign {
+		require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o755))
+		require.NoError(t, os.WriteFile(file, []byte("data"), 0o600))
+	}
+
+	second := newApp(3)
+	second.files.removals.Wait()
+	require.NoFileExists(t, orphan)
+	for _, file := range foreign {
+		require.FileExists(t, file)
+	}
+	// давно не читанное превью вытеснено при восстановлении
+	require.NoFileExists(t, files[2])
+	require.NoFileExists(t, metaPath(files[2]))
+
+	for _, path := range paths[:2] {
+		preview, err := second.PreviewUpload(context.Background(), path, img)
+		require.NoError(t, err)
+		require.True(t, preview.FromCache, path)
+	}
+	preview, err := second.Preview(context.Background(), dataPath, http.Header{})
+	require.NoError(t, err)
+	require.True(t, preview.FromCache)
+	_, ok := second.index.state(sourceOf(transformKey(dataPath)))
+	require.True(t, ok)
+	// восстановленные превью с давним обращением отмечаются в описании в фоне
+	second.files.touches.Wait()
+}
+
+func TestRestoreDropsStaleVariants(t *testing.T) {
+	store := newFileStore(t.TempDir(), nopLogger{})
+	ref := "a.com/img.jpg"
+	now := time.Now()
+	old := &sourceState{Digest: "01", Expires: now}
+	current := &sourceState{Digest: "02", Expires: now.Add(time.Hour)}
+
+	_, err := store.save(previewFileName("/fill/1/1/"+ref), []byte("old"), previewMeta{Key: "/fill/1/1/" + ref, Source: old})
+	require.NoError(t, err)
+	_, err = store.save(previewFileName("/fill/2/2/"+ref), []byte("new"), previewMeta{Key: "/fill/2/2/" + ref, Source: current})
+	require.NoError(t, err)
+
+	app := New(config.Config{Storage: config.StorageCfg{Path: store.root}},
+		cache.New[string, Entry](config.CacheCfg{Capacity: 10}), source.NewRegistry(nil), nopLogger{})
+	app.files.removals.Wait()
+
+	_, ok := app.cache.Get("/fill/1/1/" + ref)
+	require.False(t, ok)
+	require.NoFileExists(t, filepath.Join(store.root, previewFileName("/fill/1/1/"+ref)))
+	_, ok = app.cache.Get("/fill/2/2/" + ref)
+	require.True(t, ok)
+	state, ok := app.index.state(ref)
+	require.True(t, ok)
+	require.Equal(t, "02", state.Digest[:2])
+}
+
+func TestRestoreDropsExpired(t *testing.T) {
+	store := newFileStore(t.TempDir(), nopLogger{})
+	now := time.Now()
+	expired := previewMeta{Key: "/fill/1/1/a.com/img.jpg", Expires: now.Add(-time.Minute)}
+	alive := previewMeta{Key: "/fill/2/2/a.com/img.jpg", Expires: now.Add(time.Hour)}
+	for _, meta := range []previewMeta{expired, alive} {
+		_, err := store.save(previewFileName(meta.Key), []byte("preview"), meta)
+		require.NoError(t, err)
+	}
+
+	app := New(config.Config{Storage: config.StorageCfg{Path: store.root}},
+		cache.New[string, Entry](config.CacheCfg{Capacity: 10}), source.NewRegistry(nil), nopLogger{})
+	app.files.removals.Wait()
+
+	_, ok := app.cache.Get(expired.Key)
+	require.False(t, ok)
+	require.NoFileExists(t, filepath.Join(store.root, previewFileName(expired.Key)))
+	_, ok = app.cache.Get(alive.Key)
+	require.True(t, ok)
+}
+
+func TestTouchRecordsAccess(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+	app := newTestApp(config.Config{Storage: config.StorageCfg{Path: t.TempDir()}})
+
+	_, err = app.PreviewUpload(context.Background(), "/fill/50/40", img)
+	require.NoError(t, err)
+	key := app.cache.Keys()[0]
+	entry, ok := app.cache.Peek(key)
+	require.True(t, ok)
+	metaFile := metaPath(filepath.Join(app.files.root, entry.Path()))
+	readAccess := func() time.Time {
+		data, err := os.ReadFile(metaFile)
+		require.NoError(t, err)
+		var meta previewMeta
+		require.NoError(t, json.Unmarshal(data, &meta))
+		return meta.AccessedAt
+	}
+	saved := readAccess()
+	require.False(t, saved.IsZero())
+
+	// чаще touchInterval обращения в описание не пишутся
+	_, err = app.PreviewUpload(context.Background(), "/fill/50/40", img)
+	require.NoError(t, err)
+	app.files.touches.Wait()
+	require.Equal(t, saved, readAccess())
+
+	// обращение из кэша в памяти тоже отмечается в описании
+	app.files.mu.Lock()
+	app.files.touched[entry.Path()] = saved.Add(-touchInterval)
+	app.files.mu.Unlock()
+	preview, err := app.PreviewUpload(context.Background(), "/fill/50/40", img)
+	require.NoError(t, err)
+	require.True(t, preview.FromCache)
+	app.files.touches.Wait()
+	require.True(t, readAccess().After(saved))
+}
@@ -2,82 +2,403 @@ package cache
 
 import (
 	"sync"
+	"time"
 
 	"github.com/Ser9unin/ImagePreviewer/internal/config"
 )
 
 type Key string
 
-type Cache interface {
-	Set(key string, value interface{}) bool
-	Get(key string) (interface{}, bool)
+// Cache кэш значений типа V по ключам типа K.
+type Cache[K comparable, V any] interface {
+	Set(key K, value V) bool
+	SetItem(key K, value V, opts ItemOptions) bool
+	Get(key K) (V, bool)
+	// Peek возвращает значение записи, не отмечая обращение к ней.
+	Peek(key K) (V, bool)
+	// Keys возвращает ключи всех неустаревших записей.
+	Keys() []K
+	// LocalKeys возвращает ключи записей этого экземпляра, без перебора общего хранилища.
+	LocalKeys() []K
+	Remove(key K) bool
 	Clear()
+	OnEvict(fn EvictFunc[K, V])
+	// Stats возвращает счетчики обращений и текущий размер кэша.
+	Stats() Stats
+	Close()
 }
 
-type lruCache struct {
+// Stats счетчики кэша с момента создания и его текущий размер.
+type Stats struct {
+	// Hits и Misses обращения через Get, нашедшие и не нашедшие запись (устаревшая запись - промах).
+	Hits   int64
+	Misses int64
+	// Sets сохраненные записи.
+	Sets int64
+	// Evictions записи, вытесненные при переполнении, Expirations - удаленные по истечении срока.
+	// Удаленные через Remove и Clear не учитываются.
+	Evictions   int64
+	Expirations int64
+	// Entries число записей, Bytes - их суммарный вес.
+	Entries int
+	Bytes   int64
+	// RemoteHits записи, найденные в общем хранилище, RemoteErrors - ошибки обращения к нему
+	// (только у кэша с общим хранилищем, см. NewShared).
+	RemoteHits   int64
+	RemoteErrors int64
+}
+
+// HitRate доля попаданий среди обращений через Get.
+func (s Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// add суммирует счетчики, например, сегментов кэша.
+func (s Stats) add(other Stats) Stats {
+	s.Hits += other.Hits
+	s.Misses += other.Misses
+	s.Sets += other.Sets
+	s.Evictions += other.Evictions
+	s.Expirations += other.Expirations
+	s.Entries += other.Entries
+	s.Bytes += other.Bytes
+	s.RemoteHits += other.RemoteHits
+	s.RemoteErrors += other.RemoteErrors
+	return s
+}
+
+// EvictFunc вызывается для каждой записи, покинувшей кэш: вытесненной при переполнении,
+// устаревшей, удаленной через Remove или Clear. При перезаписи значения по тому же ключу не вызывается.
+// Вызов происходит вне блокировки кэша, поэтому из EvictFunc можно обращаться к кэшу.
+type EvictFunc[K comparable, V any] func(key K, value V)
+
+// ItemOptions параметры записи в кэше.
+type ItemOptions struct {
+	// Weight вес записи, например, объем файла в байтах. Учитывается в лимите CacheCfg.MaxBytes.
+	Weight int64
+	// TTL срок жизни записи, 0 - срок по-умолчанию CacheCfg.TTL.
+	TTL time.Duration
+}
+
+// policyCache вытесняет записи, выбранные политикой вытеснения (по-умолчанию LRU), пока число записей
+// больше CacheCfg.Capacity или их суммарный вес больше CacheCfg.MaxBytes (нулевой лимит не действует).
+// Устаревшие записи удаляются при обращении к ним и фоновой очисткой раз в CacheCfg.JanitorInterval.
+type policyCache[K comparable, V any] struct {
 	goroutineLock sync.Mutex
 	capacity      config.CacheCfg
-	queue         List
-	items         map[string]*ListItem
+	policy        policy[K, V]
+	items         map[K]*cacheItem[K, V]
+	onEvict       EvictFunc[K, V]
+	// size суммарный вес записей.
+	size  int64
+	stats Stats
+	now   func() time.Time
+	// stop останавливает фоновую очистку, done закрывается после ее завершения.
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-type cacheItem struct {
-	key string
-	val interface{}
+type cacheItem[K comparable, V any] struct {
+	key    K
+	val    V
+	weight int64
+	// expires момент устаревания записи, нулевое значение - запись не устаревает.
+	expires time.Time
+
+	// положение записи в очередях политики вытеснения
+	node  *ListItem[*cacheItem[K, V]]
+	queue uint8
+	freq  int
+}
+
+func (i *cacheItem[K, V]) expired(now time.Time) bool {
+	return !i.expires.IsZero() && !now.Before(i.expires)
+}
+
+// NewCache кэш значений любого типа по строковым ключам, как до появления Cache[K, V].
+func NewCache(capacity config.CacheCfg) Cache[string, interface{}] {
+	return New[string, interface{}](capacity)
+}
+
+// New создает кэш с политикой вытеснения CacheCfg.Policy. Если CacheCfg.Shards больше 1,
+// записи делятся между независимо блокируемыми сегментами. Сегментов не бывает больше,
+// чем CacheCfg.Capacity, чтобы в каждом было место хотя бы для одной записи.
+func New[K comparable, V any](capacity config.CacheCfg) Cache[K, V] {
+	if capacity.Capacity > 0 {
+		capacity.Shards = min(capacity.Shards, capacity.Capacity)
+	}
+	if capacity.MaxBytes > 0 {
+		capacity.Shards = int(min(int64(capacity.Shards), capacity.MaxBytes))
+	}
+	if capacity.Shards > 1 {
+		return newShardedCache[K, V](capacity)
+	}
+	return newPolicyCache[K, V](capacity)
 }
 
-func NewCache(capacity config.CacheCfg) Cache {
+func newPolicyCache[K comparable, V any](capacity config.CacheCfg) *policyCache[K, V] {
 	capacityInt := capacity.Capacity
 	if capacityInt < 1 {
 		capacityInt = 1
 	}
-	return &lruCache{
+	c := &policyCache[K, V]{
 		capacity: capacity,
-		queue:    NewList(),
-		items:    make(map[string]*ListItem, capacityInt),
+		policy:   newPolicy[K, V](capacity),
+		items:    make(map[K]*cacheItem[K, V], capacityInt),
+		now:      time.Now,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
 	}
+	if capacity.JanitorInterval > 0 {
+		go c.janitor(capacity.JanitorInterval)
+	} else {
+		close(c.done)
+	}
+	return c
 }
 
-func (l *lruCache) Set(key string, value interface{}) bool {
-	l.goroutineLock.Lock()
-	defer l.goroutineLock.Unlock()
+// Close останавливает фоновую очистку и дожидается ее завершения.
+func (c *policyCache[K, V]) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+	<-c.done
+}
 
-	_, keyInCache := l.items[key]
+// janitor раз в interval удаляет устаревшие записи.
+func (c *policyCache[K, V]) janitor(interval time.Duration) {
+	defer close(c.done)
 
-	if keyInCache {
-		l.queue.Remove(l.items[key])
-	} else if l.queue.Len() >= l.capacity.Capacity {
-		itemToRemove := l.queue.Back().Value.(cacheItem)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.removeExpired()
+		}
+	}
+}
 
-		l.queue.Remove(l.items[itemToRemove.key])
-		delete(l.items, itemToRemove.key)
+// removeExpired удаляет все устаревшие записи.
+func (c *policyCache[K, V]) removeExpired() {
+	c.goroutineLock.Lock()
+
+	var evicted []*cacheItem[K, V]
+	now := c.now()
+	for _, item := range c.items {
+		if item.expired(now) {
+			c.removeItem(item)
+			c.stats.Expirations++
+			evicted = append(evicted, item)
+		}
 	}
+	onEvict := c.onEvict
+	c.goroutineLock.Unlock()
+
+	notifyEvicted(onEvict, evicted)
+}
 
-	l.items[key] = l.queue.PushFront(cacheItem{key: key, val: value})
+// OnEvict задает функцию, которая вызывается для записей, покидающих кэш.
+func (c *policyCache[K, V]) OnEvict(fn EvictFunc[K, V]) {
+	c.goroutineLock.Lock()
+	defer c.goroutineLock.Unlock()
+
+	c.onEvict = fn
+}
 
+func (c *policyCache[K, V]) Set(key K, value V) bool {
+	return c.SetItem(key, value, ItemOptions{})
+}
+
+// SetItem сохраняет запись с весом opts.Weight и сроком жизни opts.TTL и вытесняет записи,
+// выбранные политикой, пока не уложится в лимиты. Запись тяжелее всего кэша не сохраняется,
+// прежнее значение по этому ключу при этом удаляется.
+func (c *policyCache[K, V]) SetItem(key K, value V, opts ItemOptions) bool {
+	c.goroutineLock.Lock()
+
+	var evicted []*cacheItem[K, V]
+	existing, keyInCache := c.items[key]
+	if keyInCache {
+		c.removeItem(existing)
+	}
+
+	if c.capacity.MaxBytes > 0 && opts.Weight > c.capacity.MaxBytes {
+		if keyInCache {
+			evicted = append(evicted, existing)
+		}
+	} else {
+		for c.overflows(opts.Weight) {
+			victim := c.policy.evict()
+			c.forget(victim)
+			c.stats.Evictions++
+			evicted = append(evicted, victim)
+		}
+		item := &cacheItem[K, V]{key: key, val: value, weight: opts.Weight, expires: c.expiry(opts.TTL)}
+		c.items[key] = item
+		c.size += item.weight
+		c.policy.add(item)
+		c.stats.Sets++
+	}
+	onEvict := c.onEvict
+	c.goroutineLock.Unlock()
+
+	notifyEvicted(onEvict, evicted)
 	return keyInCache
 }
 
-func (l *lruCache) Get(key string) (interface{}, bool) {
-	l.goroutineLock.Lock()
-	defer l.goroutineLock.Unlock()
+// overflows сообщает, что для новой записи весом weight нужно вытеснить одну из имеющихся.
+func (c *policyCache[K, V]) overflows(weight int64) bool {
+	if len(c.items) == 0 {
+		return false
+	}
+	if c.capacity.Capacity > 0 && len(c.items) >= c.capacity.Capacity {
+		return true
+	}
+	return c.capacity.MaxBytes > 0 && c.size+weight > c.capacity.MaxBytes
+}
+
+// expiry момент устаревания записи со сроком жизни ttl.
+func (c *policyCache[K, V]) expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		ttl = c.capacity.TTL
+	}
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return c.now().Add(ttl)
+}
+
+// removeItem удаляет запись из кэша и из очередей политики.
+func (c *policyCache[K, V]) removeItem(item *cacheItem[K, V]) {
+	c.policy.remove(item)
+	c.forget(item)
+}
+
+// forget удаляет запись, которую политика уже не учитывает.
+func (c *policyCache[K, V]) forget(item *cacheItem[K, V]) {
+	delete(c.items, item.key)
+	c.size -= item.weight
+}
+
+// Get возвращает значение записи. Устаревшая запись удаляется, как если бы ее не было.
+func (c *policyCache[K, V]) Get(key K) (V, bool) {
+	c.goroutineLock.Lock()
 
-	itemInCache, keyInCache := l.items[key]
+	item, keyInCache := c.items[key]
 
 	if !keyInCache {
-		return nil, false
+		c.stats.Misses++
+		c.goroutineLock.Unlock()
+		var zero V
+		return zero, false
 	}
 
-	itemVal := itemInCache.Value.(cacheItem).val
-	l.queue.MoveToFront(itemInCache)
+	if item.expired(c.now()) {
+		c.removeItem(item)
+		c.stats.Misses++
+		c.stats.Expirations++
+		onEvict := c.onEvict
+		c.goroutineLock.Unlock()
+
+		notifyEvicted(onEvict, []*cacheItem[K, V]{item})
+		var zero V
+		return zero, false
+	}
+	c.policy.touch(item)
+	c.stats.Hits++
+	c.goroutineLock.Unlock()
+
+	return item.val, keyInCache
+}
+
+// Peek возвращает значение записи, не меняя порядок вытеснения. Устаревшая запись не отдается.
+func (c *policyCache[K, V]) Peek(key K) (V, bool) {
+	c.goroutineLock.Lock()
+	defer c.goroutineLock.Unlock()
 
-	return itemVal, keyInCache
+	item, ok := c.items[key]
+	if !ok || item.expired(c.now()) {
+		var zero V
+		return zero, false
+	}
+	return item.val, true
 }
 
-func (l *lruCache) Clear() {
-	l.goroutineLock.Lock()
-	defer l.goroutineLock.Unlock()
+func (c *policyCache[K, V]) Keys() []K {
+	c.goroutineLock.Lock()
+	defer c.goroutineLock.Unlock()
+
+	now := c.now()
+	keys := make([]K, 0, len(c.items))
+	for key, item := range c.items {
+		if !item.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
 
-	l.queue = NewList()
-	l.items = make(map[string]*ListItem, l.capacity.Capacity)
+func (c *policyCache[K, V]) LocalKeys() []K {
+	return c.Keys()
+}
+
+func (c *policyCache[K, V]) Remove(key K) bool {
+	c.goroutineLock.Lock()
+
+	item, keyInCache := c.items[key]
+	if !keyInCache {
+		c.goroutineLock.Unlock()
+		return false
+	}
+
+	c.removeItem(item)
+	onEvict := c.onEvict
+	c.goroutineLock.Unlock()
+
+	notifyEvicted(onEvict, []*cacheItem[K, V]{item})
+	return true
+}
+
+func (c *policyCache[K, V]) Clear() {
+	c.goroutineLock.Lock()
+
+	var evicted []*cacheItem[K, V]
+	if c.onEvict != nil {
+		evicted = make([]*cacheItem[K, V], 0, len(c.items))
+		for _, item := range c.items {
+			evicted = append(evicted, item)
+		}
+	}
+	c.policy = newPolicy[K, V](c.capacity)
+	c.items = make(map[K]*cacheItem[K, V], max(c.capacity.Capacity, 0))
+	c.size = 0
+	onEvict := c.onEvict
+	c.goroutineLock.Unlock()
+
+	notifyEvicted(onEvict, evicted)
+}
+
+func (c *policyCache[K, V]) Stats() Stats {
+	c.goroutineLock.Lock()
+	defer c.goroutineLock.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.items)
+	stats.Bytes = c.size
+	return stats
+}
+
+func notifyEvicted[K comparable, V any](onEvict EvictFunc[K, V], evicted []*cacheItem[K, V]) {
+	if onEvict == nil {
+		return
+	}
+	for _, item := range evicted {
+		onEvict(item.key, item.val)
+	}
 }
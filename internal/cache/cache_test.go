@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/Ser9unin/ImagePreviewer/internal/config"
 	"github.com/stretchr/testify/require"
@@ -53,6 +54,198 @@ func TestCache(t *testing.T) {
 		require.False(t, ok)
 		require.Nil(t, val)
 	})
+
+	t.Run("remove", func(t *testing.T) {
+		capCache.Capacity = 3
+		c := NewCache(capCache)
+
+		c.Set("aaa", 100)
+		c.Set("bbb", 200)
+		c.Get("aaa")
+
+		require.True(t, c.Remove("aaa"))
+		require.False(t, c.Remove("aaa"))
+
+		_, ok := c.Get("aaa")
+		require.False(t, ok)
+
+		val, ok := c.Get("bbb")
+		require.True(t, ok)
+		require.Equal(t, 200, val)
+
+		c.Set("ccc", 300)
+		c.Set("ddd", 400)
+		c.Set("eee", 500) // [eee, ddd, ccc], bbb вытеснен
+
+		_, ok = c.Get("bbb")
+		require.False(t, ok)
+		for _, key := range []string{"ccc", "ddd", "eee"} {
+			_, ok = c.Get(key)
+			require.True(t, ok)
+		}
+	})
+
+	t.Run("on evict", func(t *testing.T) {
+		capCache.Capacity = 2
+		c := NewCache(capCache)
+
+		evicted := map[string]interface{}{}
+		c.OnEvict(func(key string, value interface{}) {
+			evicted[key] = value
+			// из функции можно обращаться к кэшу
+			_, ok := c.Get(key)
+			require.False(t, ok)
+		})
+
+		c.Set("aaa", 100)
+		c.Set("bbb", 200)
+		c.Set("aaa", 101) // перезапись не вытесняет
+		require.Empty(t, evicted)
+
+		c.Set("ccc", 300) // [ccc, aaa], bbb вытеснен
+		require.Equal(t, map[string]interface{}{"bbb": 200}, evicted)
+
+		c.Remove("aaa")
+		require.Equal(t, 101, evicted["aaa"])
+
+		c.Clear()
+		require.Equal(t, 300, evicted["ccc"])
+		require.Len(t, evicted, 3)
+	})
+}
+
+func TestCacheMaxBytes(t *testing.T) {
+	c := NewCache(config.CacheCfg{MaxBytes: 10})
+	var evicted []string
+	c.OnEvict(func(key string, _ interface{}) {
+		evicted = append(evicted, key)
+	})
+
+	c.SetItem("aaa", 1, ItemOptions{Weight: 4})
+	c.SetItem("bbb", 2, ItemOptions{Weight: 4})
+	c.Get("aaa") // [aaa, bbb]
+
+	// вытесняется столько записей, сколько нужно, чтобы уложиться в лимит
+	c.SetItem("ccc", 3, ItemOptions{Weight: 6}) // [ccc, aaa], bbb вытеснен
+	require.Equal(t, []string{"bbb"}, evicted)
+	c.SetItem("ddd", 4, ItemOptions{Weight: 9}) // [ddd]
+	require.Equal(t, []string{"bbb", "aaa", "ccc"}, evicted)
+
+	// перезапись учитывает новый вес
+	c.SetItem("ddd", 5, ItemOptions{Weight: 1})
+	c.SetItem("eee", 6, ItemOptions{Weight: 9})
+	require.Len(t, evicted, 3)
+
+	// запись тяжелее всего кэша не сохраняется, а прежнее значение удаляется
+	c.SetItem("eee", 7, ItemOptions{Weight: 11})
+	_, ok := c.Get("eee")
+	require.False(t, ok)
+	require.Equal(t, "eee", evicted[3])
+	val, ok := c.Get("ddd")
+	require.True(t, ok)
+	require.Equal(t, 5, val)
+
+	t.Run("both limits", func(t *testing.T) {
+		c := NewCache(config.CacheCfg{Capacity: 2, MaxBytes: 100})
+		c.SetItem("aaa", 1, ItemOptions{Weight: 1})
+		c.SetItem("bbb", 2, ItemOptions{Weight: 1})
+		c.SetItem("ccc", 3, ItemOptions{Weight: 1})
+		_, ok := c.Get("aaa")
+		require.False(t, ok)
+
+		c.SetItem("ddd", 4, ItemOptions{Weight: 100})
+		_, ok = c.Get("bbb")
+		require.False(t, ok)
+		_, ok = c.Get("ccc")
+		require.False(t, ok)
+	})
+}
+
+func TestCacheTTL(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewCache(config.CacheCfg{TTL: time.Minute})
+	l := c.(*policyCache[string, interface{}])
+	l.now = func() time.Time { return now }
+	var evicted []string
+	c.OnEvict(func(key string, _ interface{}) {
+		evicted = append(evicted, key)
+	})
+
+	c.Set("aaa", 1)                                    // срок по-умолчанию
+	c.SetItem("bbb", 2, ItemOptions{TTL: time.Hour})   // свой срок
+	c.SetItem("ccc", 3, ItemOptions{TTL: time.Second}) // короткий срок
+
+	now = now.Add(time.Minute)
+	// устаревшая запись удаляется при обращении
+	_, ok := c.Get("aaa")
+	require.False(t, ok)
+	require.Equal(t, []string{"aaa"}, evicted)
+	val, ok := c.Get("bbb")
+	require.True(t, ok)
+	require.Equal(t, 2, val)
+
+	// остальные устаревшие записи удаляет очистка
+	l.removeExpired()
+	require.Equal(t, []string{"aaa", "ccc"}, evicted)
+	_, ok = c.Get("bbb")
+	require.True(t, ok)
+
+	t.Run("without ttl", func(t *testing.T) {
+		c := NewCache(config.CacheCfg{})
+		c.(*policyCache[string, interface{}]).now = func() time.Time { return now.Add(100 * 365 * 24 * time.Hour) }
+		c.Set("aaa", 1)
+		_, ok := c.Get("aaa")
+		require.True(t, ok)
+	})
+}
+
+func TestCacheStats(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := New[string, int](config.CacheCfg{MaxBytes: 30, TTL: time.Minute})
+	c.(*policyCache[string, int]).now = func() time.Time { return now }
+
+	c.SetItem("aaa", 1, ItemOptions{Weight: 10})
+	c.SetItem("bbb", 2, ItemOptions{Weight: 10, TTL: time.Hour})
+	c.SetItem("ccc", 3, ItemOptions{Weight: 10})
+	c.SetItem("ddd", 4, ItemOptions{Weight: 10}) // вытесняет aaa
+	c.Get("bbb")
+	c.Get("aaa")
+	c.Peek("ccc")
+	require.Equal(t, Stats{Hits: 1, Misses: 1, Sets: 4, Evictions: 1, Entries: 3, Bytes: 30}, c.Stats())
+	require.InDelta(t, 0.5, c.Stats().HitRate(), 0.001)
+
+	now = now.Add(time.Minute)
+	c.Get("ccc")
+	c.(*policyCache[string, int]).removeExpired()
+	c.Remove("bbb")
+	require.Equal(t, Stats{Hits: 1, Misses: 2, Sets: 4, Evictions: 1, Expirations: 2}, c.Stats())
+	require.Zero(t, Stats{}.HitRate())
+}
+
+func TestCacheJanitor(t *testing.T) {
+	c := NewCache(config.CacheCfg{TTL: time.Millisecond, JanitorInterval: time.Millisecond})
+	evicted := make(chan string, 1)
+	c.OnEvict(func(key string, _ interface{}) {
+		evicted <- key
+	})
+
+	c.Set("aaa", 1)
+	select {
+	case key := <-evicted:
+		require.Equal(t, "aaa", key)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "expired item was not removed")
+	}
+
+	c.Close()
+	c.Close()
+	// после остановки очистки устаревшие записи удаляются только при обращении
+	c.Set("bbb", 2)
+	time.Sleep(10 * time.Millisecond)
+	require.Empty(t, evicted)
+	_, ok := c.Get("bbb")
+	require.False(t, ok)
+	require.Equal(t, "bbb", <-evicted)
 }
 
 func TestCacheMultithreading(_ *testing.T) {
@@ -78,3 +271,46 @@ func TestCacheMultithreading(_ *testing.T) {
 
 	wg.Wait()
 }
+
+func TestTypedCache(t *testing.T) {
+	type entry struct {
+		path string
+		size int64
+	}
+	c := New[int, entry](config.CacheCfg{Capacity: 2})
+	var evicted []int
+	c.OnEvict(func(key int, value entry) {
+		evicted = append(evicted, key)
+		require.Equal(t, int64(key), value.size)
+	})
+
+	c.Set(1, entry{path: "a.jpg", size: 1})
+	c.Set(2, entry{path: "b.jpg", size: 2})
+	c.Set(3, entry{path: "c.jpg", size: 3})
+	require.Equal(t, []int{1}, evicted)
+
+	value, ok := c.Get(3)
+	require.True(t, ok)
+	require.Equal(t, "c.jpg", value.path)
+
+	value, ok = c.Get(1)
+	require.False(t, ok)
+	require.Zero(t, value)
+
+	// Peek не меняет порядок вытеснения: вытесняется 2, хотя его просматривали
+	value, ok = c.Peek(2)
+	require.True(t, ok)
+	require.Equal(t, "b.jpg", value.path)
+	require.ElementsMatch(t, []int{2, 3}, c.Keys())
+	c.Get(3)
+	c.Set(4, entry{path: "d.jpg", size: 4})
+	require.ElementsMatch(t, []int{3, 4}, c.Keys())
+
+	sharded := New[int, entry](config.CacheCfg{Capacity: 8, Shards: 4, Policy: PolicyTinyLFU})
+	sharded.Set(1, entry{path: "a.jpg"})
+	sharded.Set(2, entry{path: "b.jpg"})
+	value, ok = sharded.Peek(1)
+	require.True(t, ok)
+	require.Equal(t, "a.jpg", value.path)
+	require.ElementsMatch(t, []int{1, 2}, sharded.Keys())
+}
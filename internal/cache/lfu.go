@@ -0,0 +1,59 @@
+package cache
+
+// lfu вытесняет записи с наименьшим числом обращений, среди них - давно не использованные.
+// Записи хранятся в очередях по числу обращений, поэтому все операции выполняются за O(1).
+type lfu[K comparable, V any] struct {
+	buckets map[int]*queue[K, V]
+	// minFreq наименьшее число обращений среди записей, уточняется при вытеснении.
+	minFreq int
+}
+
+func newLFU[K comparable, V any]() *lfu[K, V] {
+	return &lfu[K, V]{buckets: make(map[int]*queue[K, V])}
+}
+
+func (p *lfu[K, V]) add(item *cacheItem[K, V]) {
+	item.freq = 1
+	p.bucket(1).pushFront(item, 1)
+	p.minFreq = 1
+}
+
+func (p *lfu[K, V]) touch(item *cacheItem[K, V]) {
+	p.remove(item)
+	if _, ok := p.buckets[p.minFreq]; !ok && p.minFreq == item.freq {
+		p.minFreq++
+	}
+	item.freq++
+	p.bucket(item.freq).pushFront(item, 1)
+}
+
+func (p *lfu[K, V]) remove(item *cacheItem[K, V]) {
+	bucket := p.buckets[item.freq]
+	bucket.remove(item, 1)
+	if bucket.size == 0 {
+		delete(p.buckets, item.freq)
+	}
+}
+
+func (p *lfu[K, V]) evict() *cacheItem[K, V] {
+	if _, ok := p.buckets[p.minFreq]; !ok {
+		p.minFreq = 0
+		for freq := range p.buckets {
+			if p.minFreq == 0 || freq < p.minFreq {
+				p.minFreq = freq
+			}
+		}
+	}
+	item := p.buckets[p.minFreq].back()
+	p.remove(item)
+	return item
+}
+
+func (p *lfu[K, V]) bucket(freq int) *queue[K, V] {
+	bucket, ok := p.buckets[freq]
+	if !ok {
+		bucket = newQueue[K, V]()
+		p.buckets[freq] = bucket
+	}
+	return bucket
+}
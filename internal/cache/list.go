@@ -1,45 +1,52 @@
 package cache
 
-type List interface {
+// List двусвязный список значений типа T.
+type List[T any] interface {
 	Len() int
-	Front() *ListItem
-	Back() *ListItem
-	PushFront(v interface{}) *ListItem
-	PushBack(v interface{}) *ListItem
-	Remove(i *ListItem)
-	MoveToFront(i *ListItem)
+	Front() *ListItem[T]
+	Back() *ListItem[T]
+	PushFront(v T) *ListItem[T]
+	PushBack(v T) *ListItem[T]
+	Remove(i *ListItem[T])
+	MoveToFront(i *ListItem[T])
 }
 
-type ListItem struct {
-	Value interface{}
-	Next  *ListItem
-	Prev  *ListItem
+type ListItem[T any] struct {
+	Value T
+	Next  *ListItem[T]
+	Prev  *ListItem[T]
 }
 
-type list struct {
-	FirstNode *ListItem
-	LastNode  *ListItem
+type list[T any] struct {
+	FirstNode *ListItem[T]
+	LastNode  *ListItem[T]
 	Size      int
 }
 
-func NewList() List {
-	return new(list)
+// NewList список значений любого типа, как до появления List[T].
+func NewList() List[interface{}] {
+	return NewListOf[interface{}]()
 }
 
-func (l *list) Len() int {
+// NewListOf список значений типа T.
+func NewListOf[T any]() List[T] {
+	return new(list[T])
+}
+
+func (l *list[T]) Len() int {
 	return l.Size
 }
 
-func (l *list) Front() *ListItem {
+func (l *list[T]) Front() *ListItem[T] {
 	return l.FirstNode
 }
 
-func (l *list) Back() *ListItem {
+func (l *list[T]) Back() *ListItem[T] {
 	return l.LastNode
 }
 
-func (l *list) PushFront(v interface{}) *ListItem {
-	newItem := &ListItem{
+func (l *list[T]) PushFront(v T) *ListItem[T] {
+	newItem := &ListItem[T]{
 		Value: v,
 		Next:  nil,
 		Prev:  nil,
@@ -61,12 +68,12 @@ func (l *list) PushFront(v interface{}) *ListItem {
 	return newItem
 }
 
-func (l *list) PushBack(v interface{}) *ListItem {
+func (l *list[T]) PushBack(v T) *ListItem[T] {
 	if l.LastNode == nil {
 		return l.PushFront(v)
 	}
 
-	newItem := &ListItem{
+	newItem := &ListItem[T]{
 		Value: v,
 		Next:  nil,
 		Prev:  nil,
@@ -80,7 +87,7 @@ func (l *list) PushBack(v interface{}) *ListItem {
 	return newItem
 }
 
-func (l *list) Remove(i *ListItem) {
+func (l *list[T]) Remove(i *ListItem[T]) {
 	if l.Size == 0 {
 		return
 	}
@@ -107,13 +114,26 @@ func (l *list) Remove(i *ListItem) {
 	l.Size--
 }
 
-func (l *list) MoveToFront(i *ListItem) {
-	if l.Size <= 1 {
+func (l *list[T]) MoveToFront(i *ListItem[T]) {
+	if l.Size <= 1 || i == nil {
 		return
 	}
 
-	if i != l.FirstNode {
-		l.PushFront(i.Value)
-		l.Remove(i)
+	if i == l.FirstNode {
+		return
 	}
+
+	// переставляем сам элемент, а не его копию,
+	// чтобы ссылки на него (например, из map в кэше) оставались действительными
+	i.Prev.Next = i.Next
+	if i.Next == nil {
+		l.LastNode = i.Prev
+	} else {
+		i.Next.Prev = i.Prev
+	}
+
+	i.Prev = nil
+	i.Next = l.FirstNode
+	l.FirstNode.Prev = i
+	l.FirstNode = i
 }
@@ -47,5 +47,31 @@ func TestList(t *testing.T) {
 			elems = append(elems, i.Value.(int))
 		}
 		require.Equal(t, []int{70, 80, 60, 40, 10, 30, 50}, elems)
+
+		moved := l.Front().Next.Next // 60
+		l.MoveToFront(moved)         // [60, 70, 80, 40, 10, 30, 50]
+		require.Same(t, moved, l.Front())
+		l.Remove(moved) // [70, 80, 40, 10, 30, 50]
+
+		elems = elems[:0]
+		for i := l.Front(); i != nil; i = i.Next {
+			elems = append(elems, i.Value.(int))
+		}
+		require.Equal(t, []int{70, 80, 40, 10, 30, 50}, elems)
+		require.Equal(t, 6, l.Len())
 	})
 }
+
+func TestTypedList(t *testing.T) {
+	l := NewListOf[string]()
+	l.PushBack("b")
+	l.PushFront("a")
+	l.PushBack("c")
+	l.MoveToFront(l.Back())
+
+	values := make([]string, 0, l.Len())
+	for i := l.Front(); i != nil; i = i.Next {
+		values = append(values, i.Value)
+	}
+	require.Equal(t, []string{"c", "a", "b"}, values)
+}
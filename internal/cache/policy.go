@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"fmt"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+)
+
+// Политики вытеснения, выбираются параметром CacheCfg.Policy.
+const (
+	// PolicyLRU вытесняет давно не использованные записи.
+	PolicyLRU = "lru"
+	// PolicyLFU вытесняет редко используемые записи.
+	PolicyLFU = "lfu"
+	// Policy2Q устойчива к однократному просмотру множества записей (2Q):
+	// запись попадает в основную очередь, только если к ней обратились повторно.
+	Policy2Q = "2q"
+	// PolicyTinyLFU W-TinyLFU: небольшое LRU-окно для новых записей и основная SLRU-очередь,
+	// в которую запись допускается, только если к ней обращаются чаще, чем к вытесняемой.
+	PolicyTinyLFU = "tinylfu"
+)
+
+// policy порядок вытеснения записей. Вызывается под блокировкой кэша.
+type policy[K comparable, V any] interface {
+	// add учитывает новую запись.
+	add(item *cacheItem[K, V])
+	// touch отмечает обращение к записи.
+	touch(item *cacheItem[K, V])
+	// remove забывает запись, удаленную из кэша.
+	remove(item *cacheItem[K, V])
+	// evict выбирает запись для вытеснения и забывает ее. Вызывается, только если записи есть.
+	evict() *cacheItem[K, V]
+}
+
+// CheckPolicy возвращает ошибку, если name не пустое и не имя известной политики.
+func CheckPolicy(name string) error {
+	switch name {
+	case "", PolicyLRU, PolicyLFU, Policy2Q, PolicyTinyLFU:
+		return nil
+	}
+	return fmt.Errorf("unknown cache policy %q, want %s, %s, %s or %s", name, PolicyLRU, PolicyLFU, Policy2Q, PolicyTinyLFU)
+}
+
+// newPolicy создает политику по имени из настроек, по-умолчанию LRU.
+// Неизвестное имя - ошибка программы: настройки проверяются CheckPolicy при запуске.
+func newPolicy[K comparable, V any](cfg config.CacheCfg) policy[K, V] {
+	if err := CheckPolicy(cfg.Policy); err != nil {
+		panic(err)
+	}
+	budget := newBudget(cfg)
+	switch cfg.Policy {
+	case PolicyLFU:
+		return newLFU[K, V]()
+	case Policy2Q:
+		return newTwoQueues[K, V](budget)
+	case PolicyTinyLFU:
+		return newTinyLFU[K, V](budget, cfg)
+	default:
+		return newLRU[K, V]()
+	}
+}
+
+// budget размер кэша в единицах, которыми политики измеряют свои очереди:
+// в байтах, если кэш ограничен объемом, иначе в записях.
+type budget struct {
+	byWeight bool
+	total    int64
+}
+
+func newBudget(cfg config.CacheCfg) budget {
+	if cfg.MaxBytes > 0 {
+		return budget{byWeight: true, total: cfg.MaxBytes}
+	}
+	return budget{total: int64(cfg.Capacity)}
+}
+
+// cost размер записи весом weight в единицах бюджета.
+func (b budget) cost(weight int64) int64 {
+	if b.byWeight {
+		return max(weight, 1)
+	}
+	return 1
+}
+
+// share доля бюджета percent процентов, не меньше одной единицы.
+func (b budget) share(percent int64) int64 {
+	return max(b.total*percent/100, 1)
+}
+
+// queue очередь записей политики от недавних (front) к давним (back) с их суммарным размером.
+type queue[K comparable, V any] struct {
+	list List[*cacheItem[K, V]]
+	size int64
+}
+
+func newQueue[K comparable, V any]() *queue[K, V] {
+	return &queue[K, V]{list: NewListOf[*cacheItem[K, V]]()}
+}
+
+func (q *queue[K, V]) pushFront(item *cacheItem[K, V], cost int64) {
+	item.node = q.list.PushFront(item)
+	q.size += cost
+}
+
+func (q *queue[K, V]) remove(item *cacheItem[K, V], cost int64) {
+	q.list.Remove(item.node)
+	item.node = nil
+	q.size -= cost
+}
+
+// back самая давняя запись, nil - очередь пуста.
+func (q *queue[K, V]) back() *cacheItem[K, V] {
+	if node := q.list.Back(); node != nil {
+		return node.Value
+	}
+	return nil
+}
+
+// lru вытесняет давно не использованные записи.
+type lru[K comparable, V any] struct {
+	queue *queue[K, V]
+}
+
+func newLRU[K comparable, V any]() *lru[K, V] {
+	return &lru[K, V]{queue: newQueue[K, V]()}
+}
+
+func (p *lru[K, V]) add(item *cacheItem[K, V]) {
+	p.queue.pushFront(item, 1)
+}
+
+func (p *lru[K, V]) touch(item *cacheItem[K, V]) {
+	p.queue.list.MoveToFront(item.node)
+}
+
+func (p *lru[K, V]) remove(item *cacheItem[K, V]) {
+	p.queue.remove(item, 1)
+}
+
+func (p *lru[K, V]) evict() *cacheItem[K, V] {
+	item := p.queue.back()
+	p.queue.remove(item, 1)
+	return item
+}
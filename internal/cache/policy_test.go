@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+var policies = []string{PolicyLRU, PolicyLFU, Policy2Q, PolicyTinyLFU}
+
+func TestPolicyLimits(t *testing.T) {
+	for _, name := range policies {
+		for _, cfg := range []config.CacheCfg{
+			{Policy: name, Capacity: 50},
+			{Policy: name, MaxBytes: 1000},
+			{Policy: name, Capacity: 30, MaxBytes: 1000},
+		} {
+			t.Run(fmt.Sprintf("%s %d %d", name, cfg.Capacity, cfg.MaxBytes), func(t *testing.T) {
+				c := NewCache(cfg)
+				evicted := 0
+				c.OnEvict(func(string, interface{}) { evicted++ })
+
+				rnd := rand.New(rand.NewSource(1))
+				for i := 0; i < 5000; i++ {
+					key := strconv.Itoa(rnd.Intn(200))
+					switch op := rnd.Intn(10); {
+					case op < 5:
+						c.Get(key)
+					case op < 9:
+						c.SetItem(key, i, ItemOptions{Weight: int64(rnd.Intn(50) + 1)})
+					default:
+						c.Remove(key)
+					}
+					requireLimits(t, c.(*policyCache[string, interface{}]))
+				}
+				require.Positive(t, evicted)
+
+				c.Clear()
+				require.Empty(t, c.(*policyCache[string, interface{}]).items)
+				c.Set("aaa", 1)
+				val, ok := c.Get("aaa")
+				require.True(t, ok)
+				require.Equal(t, 1, val)
+			})
+		}
+	}
+}
+
+func requireLimits(t *testing.T, c *policyCache[string, interface{}]) {
+	t.Helper()
+	if c.capacity.Capacity > 0 {
+		require.LessOrEqual(t, len(c.items), c.capacity.Capacity)
+	}
+	if c.capacity.MaxBytes > 0 {
+		require.LessOrEqual(t, c.size, c.capacity.MaxBytes)
+	}
+	var size int64
+	for _, item := range c.items {
+		size += item.weight
+		require.NotNil(t, item.node, item.key)
+	}
+	require.Equal(t, size, c.size)
+}
+
+func TestCheckPolicy(t *testing.T) {
+	for _, name := range append([]string{""}, policies...) {
+		require.NoError(t, CheckPolicy(name))
+	}
+	require.Error(t, CheckPolicy("tiny-lfu"))
+	require.Panics(t, func() { NewCache(config.CacheCfg{Capacity: 1, Policy: "tiny-lfu"}) })
+}
+
+func TestLFUKeepsFrequent(t *testing.T) {
+	c := NewCache(config.CacheCfg{Policy: PolicyLFU, Capacity: 3})
+	c.Set("aaa", 1)
+	c.Set("bbb", 2)
+	c.Set("ccc", 3)
+	c.Get("aaa")
+	c.Get("aaa")
+	c.Get("bbb")
+
+	c.Set("ddd", 4) // вытесняется ccc, к которой не обращались
+	_, ok := c.Get("ccc")
+	require.False(t, ok)
+	c.Set("eee", 5) // вытесняется ddd, среди редких - давняя
+	_, ok = c.Get("ddd")
+	require.False(t, ok)
+	for _, key := range []string{"aaa", "bbb", "eee"} {
+		_, ok := c.Get(key)
+		require.True(t, ok, key)
+	}
+}
+
+// TestScanResistance популярные записи переживают однократный просмотр множества других.
+func TestScanResistance(t *testing.T) {
+	const hot = 20
+	for name, survives := range map[string]bool{
+		PolicyLRU:     false,
+		Policy2Q:      true,
+		PolicyTinyLFU: true,
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := NewCache(config.CacheCfg{Policy: name, Capacity: 100})
+			access := func(key string) {
+				if _, ok := c.Get(key); !ok {
+					c.Set(key, key)
+				}
+			}
+			// популярные записи вперемешку с редкими
+			rnd := rand.New(rand.NewSource(1))
+			for i := 0; i < 2000; i++ {
+				if i%2 == 0 {
+					access("hot" + strconv.Itoa(rnd.Intn(hot)))
+				} else {
+					access("cold" + strconv.Itoa(i))
+				}
+			}
+			for i := 0; i < 1000; i++ {
+				access("scan" + strconv.Itoa(i))
+			}
+
+			kept := 0
+			for i := 0; i < hot; i++ {
+				if _, ok := c.Get("hot" + strconv.Itoa(i)); ok {
+					kept++
+				}
+			}
+			if survives {
+				require.Equal(t, hot, kept)
+			} else {
+				require.Zero(t, kept)
+			}
+		})
+	}
+}
+
+func TestCountMinSketch(t *testing.T) {
+	s := newCountMinSketch(64)
+	for i := 0; i < 10; i++ {
+		s.increment(hashKey("aaa"))
+	}
+	s.increment(hashKey("bbb"))
+	require.GreaterOrEqual(t, s.estimate(hashKey("aaa")), uint8(10))
+	require.GreaterOrEqual(t, s.estimate(hashKey("bbb")), uint8(1))
+	require.Less(t, s.estimate(hashKey("bbb")), s.estimate(hashKey("aaa")))
+
+	// счетчик ограничен
+	for i := 0; i < 100; i++ {
+		s.increment(hashKey("ccc"))
+	}
+	require.Equal(t, uint8(sketchMaxCount), s.estimate(hashKey("ccc")))
+
+	// давние обращения забываются
+	s.reset()
+	require.Equal(t, uint8(sketchMaxCount/2), s.estimate(hashKey("ccc")))
+}
+
+// zipfTrace последовательность ключей с распределением Ципфа, как у запросов популярных
+// изображений, с периодическими однократными просмотрами (обход сайта краулером).
+func zipfTrace(n int, keys uint64, scanEvery, scanLen int) []string {
+	rnd := rand.New(rand.NewSource(42))
+	zipf := rand.NewZipf(rnd, 1.07, 1, keys-1)
+	trace := make([]string, 0, n)
+	scanned := 0
+	for len(trace) < n {
+		if scanEvery > 0 && len(trace)%scanEvery == 0 && len(trace) > 0 {
+			for i := 0; i < scanLen && len(trace) < n; i++ {
+				trace = append(trace, "scan"+strconv.Itoa(scanned))
+				scanned++
+			}
+		}
+		trace = append(trace, strconv.FormatUint(zipf.Uint64(), 10))
+	}
+	return trace
+}
+
+// BenchmarkPolicies сравнивает политики на запросах с распределением Ципфа:
+// метрика hit% - доля попаданий в кэш.
+func BenchmarkPolicies(b *testing.B) {
+	traces := map[string][]string{
+		"zipf":      zipfTrace(200_000, 100_000, 0, 0),
+		"zipf+scan": zipfTrace(200_000, 100_000, 20_000, 5_000),
+	}
+	for _, traceName := range []string{"zipf", "zipf+scan"} {
+		trace := traces[traceName]
+		for _, name := range policies {
+			b.Run(traceName+"/"+name, func(b *testing.B) {
+				var hits, total int
+				for n := 0; n < b.N; n++ {
+					c := NewCache(config.CacheCfg{Policy: name, Capacity: 1000})
+					for _, key := range trace {
+						if _, ok := c.Get(key); ok {
+							hits++
+						} else {
+							c.Set(key, key)
+						}
+					}
+					total += len(trace)
+				}
+				b.ReportMetric(100*float64(hits)/float64(total), "hit%")
+				b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(total), "ns/access")
+			})
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+)
+
+// shardedCache делит записи по хэшу ключа между независимыми сегментами со своими
+// блокировками, чтобы одновременные запросы к разным ключам не ждали друг друга.
+// Лимиты CacheCfg делятся между сегментами поровну, в сумме не превышая их: запись
+// вытесняется, когда заполнен ее сегмент, даже если в других еще есть место, а запись
+// тяжелее доли MaxBytes одного сегмента не сохраняется.
+type shardedCache[K comparable, V any] struct {
+	shards []*policyCache[K, V]
+	// stop останавливает фоновую очистку, done закрывается после ее завершения.
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newShardedCache[K comparable, V any](cfg config.CacheCfg) *shardedCache[K, V] {
+	n := cfg.Shards
+	c := &shardedCache[K, V]{
+		shards: make([]*policyCache[K, V], n),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for i := range c.shards {
+		shardCfg := cfg
+		// остаток от деления достается первым сегментам, чтобы сумма лимитов равнялась общему
+		if cfg.Capacity > 0 {
+			shardCfg.Capacity = cfg.Capacity / n
+			if i < cfg.Capacity%n {
+				shardCfg.Capacity++
+			}
+		}
+		if cfg.MaxBytes > 0 {
+			shardCfg.MaxBytes = cfg.MaxBytes / int64(n)
+			if int64(i) < cfg.MaxBytes%int64(n) {
+				shardCfg.MaxBytes++
+			}
+		}
+		// сегменты очищаются одной общей горутиной
+		shardCfg.JanitorInterval = 0
+		c.shards[i] = newPolicyCache[K, V](shardCfg)
+	}
+	if cfg.JanitorInterval > 0 {
+		go c.janitor(cfg.JanitorInterval)
+	} else {
+		close(c.done)
+	}
+	return c
+}
+
+func (c *shardedCache[K, V]) shard(key K) *policyCache[K, V] {
+	return c.shards[hashOf(key)%uint64(len(c.shards))]
+}
+
+func (c *shardedCache[K, V]) Set(key K, value V) bool {
+	return c.shard(key).Set(key, value)
+}
+
+func (c *shardedCache[K, V]) SetItem(key K, value V, opts ItemOptions) bool {
+	return c.shard(key).SetItem(key, value, opts)
+}
+
+func (c *shardedCache[K, V]) Get(key K) (V, bool) {
+	return c.shard(key).Get(key)
+}
+
+func (c *shardedCache[K, V]) Peek(key K) (V, bool) {
+	return c.shard(key).Peek(key)
+}
+
+func (c *shardedCache[K, V]) Keys() []K {
+	var keys []K
+	for _, shard := range c.shards {
+		keys = append(keys, shard.Keys()...)
+	}
+	return keys
+}
+
+func (c *shardedCache[K, V]) LocalKeys() []K {
+	var keys []K
+	for _, shard := range c.shards {
+		keys = append(keys, shard.LocalKeys()...)
+	}
+	return keys
+}
+
+func (c *shardedCache[K, V]) Remove(key K) bool {
+	return c.shard(key).Remove(key)
+}
+
+func (c *shardedCache[K, V]) Clear() {
+	for _, shard := range c.shards {
+		shard.Clear()
+	}
+}
+
+func (c *shardedCache[K, V]) OnEvict(fn EvictFunc[K, V]) {
+	for _, shard := range c.shards {
+		shard.OnEvict(fn)
+	}
+}
+
+func (c *shardedCache[K, V]) Stats() Stats {
+	var stats Stats
+	for _, shard := range c.shards {
+		stats = stats.add(shard.Stats())
+	}
+	return stats
+}
+
+// Close останавливает фоновую очистку и дожидается ее завершения.
+func (c *shardedCache[K, V]) Close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+	<-c.done
+}
+
+// janitor раз в interval удаляет устаревшие записи из всех сегментов по очереди.
+func (c *shardedCache[K, V]) janitor(interval time.Duration) {
+	defer close(c.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			for _, shard := range c.shards {
+				shard.removeExpired()
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedCache(t *testing.T) {
+	c := NewCache(config.CacheCfg{Shards: 8, Capacity: 64})
+	sharded, ok := c.(*shardedCache[string, interface{}])
+	require.True(t, ok)
+	require.Len(t, sharded.shards, 8)
+
+	var evicted atomic.Int64
+	c.OnEvict(func(string, interface{}) { evicted.Add(1) })
+
+	for i := 0; i < 1000; i++ {
+		c.Set(strconv.Itoa(i), i)
+	}
+	total := 0
+	for _, shard := range sharded.shards {
+		require.LessOrEqual(t, len(shard.items), 8)
+		total += len(shard.items)
+	}
+	require.Equal(t, int64(1000-total), evicted.Load())
+	require.Equal(t, Stats{Sets: 1000, Evictions: int64(1000 - total), Entries: total}, c.Stats())
+
+	c.Set("aaa", 1)
+	val, ok := c.Get("aaa")
+	require.True(t, ok)
+	require.Equal(t, 1, val)
+	require.True(t, c.Remove("aaa"))
+	_, ok = c.Get("aaa")
+	require.False(t, ok)
+
+	c.Clear()
+	for _, shard := range sharded.shards {
+		require.Empty(t, shard.items)
+	}
+	require.Equal(t, int64(1001), evicted.Load())
+
+	require.IsType(t, &policyCache[string, interface{}]{}, NewCache(config.CacheCfg{Shards: 1, Capacity: 10}))
+}
+
+func TestShardedCacheLimits(t *testing.T) {
+	// сегментов не больше, чем записей, и кэш не превышает общий лимит
+	c := NewCache(config.CacheCfg{Shards: 16, Capacity: 3})
+	require.IsType(t, &shardedCache[string, interface{}]{}, c)
+	require.Len(t, c.(*shardedCache[string, interface{}]).shards, 3)
+	for i := 0; i < 100; i++ {
+		c.Set(strconv.Itoa(i), i)
+	}
+	require.LessOrEqual(t, c.Stats().Entries, 3)
+	require.IsType(t, &policyCache[string, interface{}]{}, NewCache(config.CacheCfg{Shards: 8, Capacity: 1}))
+
+	// остаток от деления лимитов достается первым сегментам
+	sharded := NewCache(config.CacheCfg{Shards: 4, Capacity: 10, MaxBytes: 1001}).(*shardedCache[string, interface{}])
+	capacity, maxBytes := 0, int64(0)
+	for i, shard := range sharded.shards {
+		require.Equal(t, []int{3, 3, 2, 2}[i], shard.capacity.Capacity)
+		capacity += shard.capacity.Capacity
+		maxBytes += shard.capacity.MaxBytes
+	}
+	require.Equal(t, 10, capacity)
+	require.Equal(t, int64(1001), maxBytes)
+}
+
+func TestShardedCacheJanitor(t *testing.T) {
+	c := NewCache(config.CacheCfg{Shards: 4, TTL: time.Millisecond, JanitorInterval: time.Millisecond})
+	var evicted atomic.Int64
+	c.OnEvict(func(string, interface{}) { evicted.Add(1) })
+
+	for i := 0; i < 100; i++ {
+		c.Set(strconv.Itoa(i), i)
+	}
+	require.Eventually(t, func() bool { return evicted.Load() == 100 }, 5*time.Second, time.Millisecond)
+	c.Close()
+	c.Close()
+}
+
+// TestShardedCacheConcurrent предназначен для запуска с -race.
+func TestShardedCacheConcurrent(t *testing.T) {
+	for _, name := range policies {
+		t.Run(name, func(t *testing.T) {
+			c := NewCache(config.CacheCfg{Shards: 8, Capacity: 100, MaxBytes: 10_000, Policy: name})
+			c.OnEvict(func(key string, _ interface{}) {
+				// из EvictFunc можно обращаться к кэшу
+				c.Get(key)
+			})
+
+			var wg sync.WaitGroup
+			for g := 0; g < 8; g++ {
+				wg.Add(1)
+				go func(seed int64) {
+					defer wg.Done()
+					rnd := rand.New(rand.NewSource(seed))
+					for i := 0; i < 2000; i++ {
+						key := strconv.Itoa(rnd.Intn(500))
+						switch op := rnd.Intn(10); {
+						case op < 6:
+							c.Get(key)
+						case op < 9:
+							c.SetItem(key, i, ItemOptions{Weight: int64(rnd.Intn(200) + 1)})
+						default:
+							c.Remove(key)
+						}
+					}
+				}(int64(g))
+			}
+			wg.Wait()
+
+			for _, shard := range c.(*shardedCache[string, interface{}]).shards {
+				requireLimits(t, shard)
+			}
+		})
+	}
+}
+
+// BenchmarkCacheParallel одновременные запросы с распределением Ципфа
+// к кэшу с одним сегментом и к сегментированному.
+func BenchmarkCacheParallel(b *testing.B) {
+	trace := zipfTrace(100_000, 10_000, 0, 0)
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			c := NewCache(config.CacheCfg{Shards: shards, Capacity: 5000})
+			for _, key := range trace {
+				c.Set(key, key)
+			}
+			var seed atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(seed.Add(7919)) % len(trace)
+				for pb.Next() {
+					key := trace[i]
+					if _, ok := c.Get(key); !ok {
+						c.Set(key, key)
+					}
+					i = (i + 1) % len(trace)
+				}
+			})
+		})
+	}
+}
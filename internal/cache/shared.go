@@ -0,0 +1,273 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+)
+
+// Remote внешнее хранилище ключей со сроком жизни, общее для нескольких экземпляров сервиса
+// (Redis и совместимые с ним).
+type Remote interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set сохраняет значение на срок ttl, 0 - без срока.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Del(ctx context.Context, keys ...string) (int, error)
+	// Scan возвращает ключи, подходящие под шаблон вида "prefix*".
+	Scan(ctx context.Context, match string) ([]string, error)
+}
+
+// Codec преобразует значения кэша в байты для общего хранилища и обратно.
+type Codec[V any] interface {
+	Encode(key string, value V) ([]byte, error)
+	Decode(key string, data []byte) (V, error)
+}
+
+// envelopeSize заголовок значения в общем хранилище: вес записи и момент ее устаревания
+// (Unix-время в наносекундах, 0 - без срока).
+const envelopeSize = 16
+
+// delBatch сколько ключей удалять одной командой при очистке.
+const delBatch = 1000
+
+var errEnvelope = errors.New("shared cache value is too short")
+
+// sharedCache локальный кэш перед общим хранилищем. Записи сохраняются в обоих,
+// промах локального кэша проверяется в хранилище, а найденная там запись попадает в локальный кэш.
+// Вытеснение из локального кэша хранилище не затрагивает, Remove и Clear удаляют записи и оттуда.
+// После ошибки хранилища оно не используется RetryInterval, и кэш работает как локальный.
+type sharedCache[V any] struct {
+	local         Cache[string, V]
+	remote        Remote
+	codec         Codec[V]
+	prefix        string
+	retryInterval time.Duration
+	now           func() time.Time
+
+	mu sync.Mutex
+	// downUntil до этого момента хранилище считается недоступным.
+	downUntil time.Time
+
+	remoteHits   atomic.Int64
+	remoteErrors atomic.Int64
+}
+
+// NewShared создает кэш, который делит записи local с другими экземплярами сервиса через remote.
+// Ключи в хранилище получают префикс cfg.Prefix, пустой заменяется на config.DefaultRedisPrefix,
+// чтобы Clear не удалял чужие ключи. Срок жизни записи в хранилище - ItemOptions.TTL,
+// запись без TTL хранится там без срока.
+func NewShared[V any](local Cache[string, V], remote Remote, codec Codec[V], cfg config.RedisCfg) Cache[string, V] {
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = config.DefaultRedisPrefix
+	}
+	return &sharedCache[V]{
+		local:         local,
+		remote:        remote,
+		codec:         codec,
+		prefix:        prefix,
+		retryInterval: cfg.RetryInterval,
+		now:           time.Now,
+	}
+}
+
+func (c *sharedCache[V]) Set(key string, value V) bool {
+	return c.SetItem(key, value, ItemOptions{})
+}
+
+func (c *sharedCache[V]) SetItem(key string, value V, opts ItemOptions) bool {
+	replaced := c.local.SetItem(key, value, opts)
+	if !c.available() {
+		return replaced
+	}
+	data, err := c.codec.Encode(key, value)
+	if err != nil {
+		c.remoteErrors.Add(1)
+		return replaced
+	}
+	var expires int64
+	if opts.TTL > 0 {
+		expires = c.now().Add(opts.TTL).UnixNano()
+	}
+	envelope := make([]byte, envelopeSize, envelopeSize+len(data))
+	binary.BigEndian.PutUint64(envelope[0:8], uint64(opts.Weight))
+	binary.BigEndian.PutUint64(envelope[8:16], uint64(expires))
+	c.check(c.remote.Set(context.Background(), c.prefix+key, append(envelope, data...), opts.TTL))
+	return replaced
+}
+
+// Get возвращает запись из локального кэша, а при промахе - из общего хранилища.
+func (c *sharedCache[V]) Get(key string) (V, bool) {
+	if value, ok := c.local.Get(key); ok {
+		return value, true
+	}
+	var zero V
+	if !c.available() {
+		return zero, false
+	}
+	data, ok, err := c.remote.Get(context.Background(), c.prefix+key)
+	if !c.check(err) || !ok {
+		return zero, false
+	}
+	value, opts, err := c.decode(key, data)
+	if err != nil {
+		c.remoteErrors.Add(1)
+		return zero, false
+	}
+	if opts.TTL < 0 {
+		return zero, false
+	}
+	c.local.SetItem(key, value, opts)
+	c.remoteHits.Add(1)
+	return value, true
+}
+
+// decode разбирает значение из хранилища, отрицательный TTL - срок записи истек.
+func (c *sharedCache[V]) decode(key string, data []byte) (V, ItemOptions, error) {
+	var zero V
+	if len(data) < envelopeSize {
+		return zero, ItemOptions{}, errEnvelope
+	}
+	opts := ItemOptions{Weight: int64(binary.BigEndian.Uint64(data[0:8]))}
+	if expires := int64(binary.BigEndian.Uint64(data[8:16])); expires != 0 {
+		if opts.TTL = time.Unix(0, expires).Sub(c.now()); opts.TTL <= 0 {
+			return zero, ItemOptions{TTL: -1}, nil
+		}
+	}
+	value, err := c.codec.Decode(key, data[envelopeSize:])
+	return value, opts, err
+}
+
+// Peek возвращает запись только из локального кэша.
+func (c *sharedCache[V]) Peek(key string) (V, bool) {
+	return c.local.Peek(key)
+}
+
+// Keys возвращает ключи локального кэша и общего хранилища.
+func (c *sharedCache[V]) Keys() []string {
+	keys := c.local.Keys()
+	remote, err := c.remoteKeys()
+	if err != nil {
+		return keys
+	}
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		seen[key] = struct{}{}
+	}
+	for _, key := range remote {
+		if _, ok := seen[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// LocalKeys возвращает ключи только локального кэша.
+func (c *sharedCache[V]) LocalKeys() []string {
+	return c.local.Keys()
+}
+
+// RemoteKeys возвращает ключи общего хранилища, подходящие под шаблон pattern в синтаксисе
+// SCAN MATCH ("*" - любая последовательность символов, "\" экранирует следующий символ).
+// Хранилище отбирает ключи само, в ответ попадают только подходящие.
+func (c *sharedCache[V]) RemoteKeys(pattern string) []string {
+	keys, err := c.scan(pattern)
+	if err != nil {
+		return nil
+	}
+	return keys
+}
+
+// remoteKeys ключи общего хранилища без префикса.
+func (c *sharedCache[V]) remoteKeys() ([]string, error) {
+	return c.scan("*")
+}
+
+// scan ключи общего хранилища без префикса, подходящие под шаблон pattern.
+func (c *sharedCache[V]) scan(pattern string) ([]string, error) {
+	if !c.available() {
+		return nil, errors.New("shared cache is unavailable")
+	}
+	keys, err := c.remote.Scan(context.Background(), c.prefix+pattern)
+	if !c.check(err) {
+		return nil, err
+	}
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, c.prefix)
+	}
+	return keys, nil
+}
+
+// Remove удаляет запись из локального кэша и из общего хранилища.
+func (c *sharedCache[V]) Remove(key string) bool {
+	removed := c.local.Remove(key)
+	if c.available() {
+		n, err := c.remote.Del(context.Background(), c.prefix+key)
+		removed = (c.check(err) && n > 0) || removed
+	}
+	return removed
+}
+
+// Clear очищает локальный кэш и удаляет все записи из общего хранилища.
+func (c *sharedCache[V]) Clear() {
+	c.local.Clear()
+	keys, err := c.remoteKeys()
+	if err != nil {
+		return
+	}
+	for start := 0; start < len(keys); start += delBatch {
+		batch := keys[start:min(start+delBatch, len(keys))]
+		for i, key := range batch {
+			batch[i] = c.prefix + key
+		}
+		if _, err := c.remote.Del(context.Background(), batch...); !c.check(err) {
+			return
+		}
+	}
+}
+
+func (c *sharedCache[V]) OnEvict(fn EvictFunc[string, V]) {
+	c.local.OnEvict(fn)
+}
+
+// Stats счетчики локального кэша, в которых попадания в общее хранилище учтены как попадания.
+func (c *sharedCache[V]) Stats() Stats {
+	stats := c.local.Stats()
+	remoteHits := c.remoteHits.Load()
+	stats.Hits += remoteHits
+	stats.Misses -= remoteHits
+	// запись, полученная из хранилища, сохраняется в локальный кэш, но не новая
+	stats.Sets -= remoteHits
+	stats.RemoteHits = remoteHits
+	stats.RemoteErrors = c.remoteErrors.Load()
+	return stats
+}
+
+func (c *sharedCache[V]) Close() {
+	c.local.Close()
+}
+
+// available сообщает, что общее хранилище можно использовать.
+func (c *sharedCache[V]) available() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.now().Before(c.downUntil)
+}
+
+// check учитывает ошибку хранилища и выключает его на RetryInterval. Возвращает true, если ошибки нет.
+func (c *sharedCache[V]) check(err error) bool {
+	if err == nil {
+		return true
+	}
+	c.remoteErrors.Add(1)
+	c.mu.Lock()
+	c.downUntil = c.now().Add(c.retryInterval)
+	c.mu.Unlock()
+	return false
+}
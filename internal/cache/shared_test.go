@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/Ser9unin/ImagePreviewer/internal/redis"
+	"github.com/Ser9unin/ImagePreviewer/internal/redis/redistest"
+	"github.com/stretchr/testify/require"
+)
+
+type stringCodec struct{}
+
+func (stringCodec) Encode(_ string, value string) ([]byte, error) { return []byte(value), nil }
+func (stringCodec) Decode(_ string, data []byte) (string, error)  { return string(data), nil }
+
+func TestSharedCache(t *testing.T) {
+	srv := redistest.NewServer()
+	defer srv.Close()
+	cfg := config.RedisCfg{Addr: srv.Addr(), Prefix: "p:", Timeout: time.Second, RetryInterval: time.Minute}
+	client, err := redis.NewClient(cfg)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.Set(context.Background(), "other", []byte("other"), 0))
+
+	// два экземпляра сервиса со своими локальными кэшами и общим хранилищем
+	a := NewShared[string](New[string, string](config.CacheCfg{Capacity: 10}), client, stringCodec{}, cfg)
+	b := NewShared[string](New[string, string](config.CacheCfg{Capacity: 10}), client, stringCodec{}, cfg)
+
+	a.SetItem("k", "value", ItemOptions{Weight: 5, TTL: time.Hour})
+	require.Equal(t, []string{"other", "p:k"}, srv.Keys())
+	require.InDelta(t, time.Hour.Seconds(), srv.TTL("p:k").Seconds(), 5)
+
+	_, ok := b.Peek("k")
+	require.False(t, ok)
+	val, ok := b.Get("k")
+	require.True(t, ok)
+	require.Equal(t, "value", val)
+	// найденная в хранилище запись сохраняется локально с весом и оставшимся сроком
+	val, ok = b.Peek("k")
+	require.True(t, ok)
+	require.Equal(t, "value", val)
+	require.Equal(t, Stats{Hits: 1, Entries: 1, Bytes: 5, RemoteHits: 1}, b.Stats())
+
+	_, ok = b.Get("missing")
+	require.False(t, ok)
+
+	a.Set("only-a", "a")
+	require.ElementsMatch(t, []string{"k", "only-a"}, b.Keys())
+	require.Equal(t, []string{"k"}, b.LocalKeys())
+	shared := b.(*sharedCache[string])
+	require.Equal(t, []string{"only-a"}, shared.RemoteKeys("only-?"))
+	require.Empty(t, shared.RemoteKeys(`only\*`))
+
+	// удаление затрагивает хранилище, локальная копия другого экземпляра остается до вытеснения
+	require.True(t, b.Remove("k"))
+	require.Equal(t, []string{"other", "p:only-a"}, srv.Keys())
+	_, ok = a.Peek("k")
+	require.True(t, ok)
+
+	// срок записи в хранилище проверяется и по ее заголовку
+	a.SetItem("short", "s", ItemOptions{TTL: time.Minute})
+	b.(*sharedCache[string]).now = func() time.Time { return time.Now().Add(time.Hour) }
+	_, ok = b.Get("short")
+	require.False(t, ok)
+
+	a.Clear()
+	require.Equal(t, []string{"other"}, srv.Keys())
+	require.Empty(t, a.Keys())
+}
+
+func TestSharedCacheDefaultPrefix(t *testing.T) {
+	srv := redistest.NewServer()
+	defer srv.Close()
+	cfg := config.RedisCfg{Addr: srv.Addr(), Timeout: time.Second, RetryInterval: time.Minute}
+	client, err := redis.NewClient(cfg)
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, client.Set(context.Background(), "other", []byte("other"), 0))
+
+	c := NewShared[string](New[string, string](config.CacheCfg{Capacity: 10}), client, stringCodec{}, cfg)
+	c.Set("k", "value")
+	require.Equal(t, []string{"other", config.DefaultRedisPrefix + "k"}, srv.Keys())
+
+	// без префикса Clear удалил бы все ключи базы
+	c.Clear()
+	require.Equal(t, []string{"other"}, srv.Keys())
+}
+
+// failingRemote хранилище, которое всегда недоступно.
+type failingRemote struct {
+	calls int
+}
+
+var errUnavailable = errors.New("unavailable")
+
+func (r *failingRemote) Get(context.Context, string) ([]byte, bool, error) {
+	r.calls++
+	return nil, false, errUnavailable
+}
+
+func (r *failingRemote) Set(context.Context, string, []byte, time.Duration) error {
+	r.calls++
+	return errUnavailable
+}
+
+func (r *failingRemote) Del(context.Context, ...string) (int, error) {
+	r.calls++
+	return 0, errUnavailable
+}
+
+func (r *failingRemote) Scan(context.Context, string) ([]string, error) {
+	r.calls++
+	return nil, errUnavailable
+}
+
+func TestSharedCacheUnavailable(t *testing.T) {
+	remote := &failingRemote{}
+	c := NewShared[string](New[string, string](config.CacheCfg{Capacity: 10}), remote, stringCodec{}, config.RedisCfg{RetryInterval: time.Minute})
+	now := time.Now()
+	c.(*sharedCache[string]).now = func() time.Time { return now }
+
+	// при недоступном хранилище кэш работает как локальный и не обращается к хранилищу до RetryInterval
+	c.Set("a", "1")
+	val, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, "1", val)
+	_, ok = c.Get("b")
+	require.False(t, ok)
+	require.Equal(t, []string{"a"}, c.Keys())
+	require.True(t, c.Remove("a"))
+	require.Equal(t, 1, remote.calls)
+	require.Equal(t, int64(1), c.Stats().RemoteErrors)
+
+	now = now.Add(time.Minute)
+	_, ok = c.Get("b")
+	require.False(t, ok)
+	require.Equal(t, 2, remote.calls)
+}
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// sketchDepth число строк count-min sketch, sketchMaxCount - предел счетчика.
+const (
+	sketchDepth    = 4
+	sketchMaxCount = 15
+)
+
+// sketchSeeds перемешивают хэш ключа для каждой строки.
+var sketchSeeds = [sketchDepth]uint64{
+	0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325,
+}
+
+// countMinSketch приблизительно считает обращения к ключам в памяти фиксированного объема.
+// Оценка не меньше настоящего числа обращений (до sketchMaxCount). Чтобы учитывались
+// недавние обращения, после sampleSize обращений все счетчики уменьшаются вдвое.
+type countMinSketch struct {
+	rows       [sketchDepth][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+// newCountMinSketch создает sketch для примерно width различных ключей.
+func newCountMinSketch(width int) *countMinSketch {
+	size := 1
+	for size < width {
+		size <<= 1
+	}
+	s := &countMinSketch{mask: uint64(size - 1), sampleSize: 10 * size}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, size)
+	}
+	return s
+}
+
+// increment учитывает обращение к ключу с хэшем h.
+func (s *countMinSketch) increment(h uint64) {
+	for i := range s.rows {
+		counter := &s.rows[i][s.index(h, i)]
+		if *counter < sketchMaxCount {
+			*counter++
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+// estimate оценивает число обращений к ключу с хэшем h.
+func (s *countMinSketch) estimate(h uint64) uint8 {
+	estimate := uint8(sketchMaxCount)
+	for i := range s.rows {
+		estimate = min(estimate, s.rows[i][s.index(h, i)])
+	}
+	return estimate
+}
+
+// reset уменьшает счетчики вдвое, чтобы давняя популярность со временем забывалась.
+func (s *countMinSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+func (s *countMinSketch) index(h uint64, row int) uint64 {
+	x := (h ^ sketchSeeds[row]) * 0x9e3779b97f4a7c15
+	x ^= x >> 32
+	return x & s.mask
+}
+
+// hashOf хэш ключа: строки хэшируются как есть, остальные ключи - по их текстовому представлению.
+func hashOf[K comparable](key K) uint64 {
+	switch k := any(key).(type) {
+	case string:
+		return hashKey(k)
+	case int:
+		return hashKey(strconv.Itoa(k))
+	case int64:
+		return hashKey(strconv.FormatInt(k, 10))
+	case uint64:
+		return hashKey(strconv.FormatUint(k, 10))
+	default:
+		return hashKey(fmt.Sprint(key))
+	}
+}
+
+// hashKey FNV-1a хэш строки без выделения памяти.
+func hashKey(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
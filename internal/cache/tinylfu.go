@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+)
+
+// Очереди W-TinyLFU, в которых может находиться запись.
+const (
+	queueWindow uint8 = iota + 1
+	queueProbation
+	queueProtected
+)
+
+// averagePreviewBytes примерный объем превью, по нему оценивается число записей
+// в кэше, ограниченном только объемом.
+const averagePreviewBytes = 32 << 10
+
+// tinyLFU политика W-TinyLFU (Einziger, Friedman, Manes, 2017). Новые записи попадают
+// в LRU-окно размером 1% кэша. Давняя запись окна переходит в основную SLRU-очередь,
+// только если по count-min sketch к ней обращаются чаще, чем к записи, которую придется
+// вытеснить, иначе вытесняется она сама. Поэтому однократный просмотр множества записей
+// не вытесняет популярные. Основная очередь делится на probation (20%), куда записи
+// попадают из окна, и protected (80%), куда переходят записи после повторного обращения.
+type tinyLFU[K comparable, V any] struct {
+	budget       budget
+	windowMax    int64
+	protectedMax int64
+	window       *queue[K, V]
+	probation    *queue[K, V]
+	protected    *queue[K, V]
+	sketch       *countMinSketch
+}
+
+func newTinyLFU[K comparable, V any](b budget, cfg config.CacheCfg) *tinyLFU[K, V] {
+	windowMax := b.share(1)
+	mainMax := max(b.total-windowMax, 1)
+
+	expected := cfg.Capacity
+	if b.byWeight {
+		expected = int(cfg.MaxBytes / averagePreviewBytes)
+	}
+	return &tinyLFU[K, V]{
+		budget:       b,
+		windowMax:    windowMax,
+		protectedMax: max(mainMax*80/100, 1),
+		window:       newQueue[K, V](),
+		probation:    newQueue[K, V](),
+		protected:    newQueue[K, V](),
+		sketch:       newCountMinSketch(min(max(expected, 64), 1<<22)),
+	}
+}
+
+func (p *tinyLFU[K, V]) add(item *cacheItem[K, V]) {
+	p.sketch.increment(hashOf(item.key))
+	p.push(p.window, queueWindow, item)
+	// место для записи уже освобождено, поэтому лишние записи окна переходят
+	// в основную очередь без сравнения
+	for p.window.size > p.windowMax && p.window.list.Len() > 1 {
+		candidate := p.window.back()
+		p.window.remove(candidate, p.budget.cost(candidate.weight))
+		p.push(p.probation, queueProbation, candidate)
+	}
+}
+
+func (p *tinyLFU[K, V]) touch(item *cacheItem[K, V]) {
+	p.sketch.increment(hashOf(item.key))
+	switch item.queue {
+	case queueWindow:
+		p.window.list.MoveToFront(item.node)
+	case queueProtected:
+		p.protected.list.MoveToFront(item.node)
+	case queueProbation:
+		p.probation.remove(item, p.budget.cost(item.weight))
+		p.push(p.protected, queueProtected, item)
+		for p.protected.size > p.protectedMax && p.protected.list.Len() > 1 {
+			demoted := p.protected.back()
+			p.protected.remove(demoted, p.budget.cost(demoted.weight))
+			p.push(p.probation, queueProbation, demoted)
+		}
+	}
+}
+
+func (p *tinyLFU[K, V]) remove(item *cacheItem[K, V]) {
+	p.queueOf(item).remove(item, p.budget.cost(item.weight))
+}
+
+func (p *tinyLFU[K, V]) evict() *cacheItem[K, V] {
+	candidate := p.window.back()
+	victim := p.probation.back()
+	if victim == nil {
+		victim = p.protected.back()
+	}
+
+	switch {
+	case victim == nil:
+		// основная очередь пуста
+		p.remove(candidate)
+		return candidate
+	case candidate == nil || p.window.size < p.windowMax:
+		p.remove(victim)
+		return victim
+	}
+
+	// окно заполнено: его давняя запись вытесняет запись основной очереди,
+	// только если к ней обращаются чаще
+	p.remove(candidate)
+	if p.sketch.estimate(hashOf(candidate.key)) > p.sketch.estimate(hashOf(victim.key)) {
+		p.remove(victim)
+		p.push(p.probation, queueProbation, candidate)
+		return victim
+	}
+	return candidate
+}
+
+func (p *tinyLFU[K, V]) push(q *queue[K, V], name uint8, item *cacheItem[K, V]) {
+	item.queue = name
+	q.pushFront(item, p.budget.cost(item.weight))
+}
+
+func (p *tinyLFU[K, V]) queueOf(item *cacheItem[K, V]) *queue[K, V] {
+	switch item.queue {
+	case queueWindow:
+		return p.window
+	case queueProbation:
+		return p.probation
+	default:
+		return p.protected
+	}
+}
@@ -0,0 +1,96 @@
+package cache
+
+// Очереди 2Q, в которых может находиться запись.
+const (
+	queueIn uint8 = iota + 1
+	queueMain
+)
+
+// twoQueues политика 2Q (Johnson, Shasha, 1994). Новые записи попадают в FIFO-очередь in
+// и вытесняются из нее первыми, запоминаясь в очереди призраков out (только ключи).
+// Запись, к которой обратились после вытеснения из in, попадает в LRU-очередь main.
+// Однократный просмотр множества записей проходит через in и не вытесняет main.
+type twoQueues[K comparable, V any] struct {
+	budget budget
+	// inMax размер очереди in, outMax - очереди призраков.
+	inMax  int64
+	outMax int64
+	in     *queue[K, V]
+	main   *queue[K, V]
+	out    List[ghost[K]]
+	// ghosts призраки в очереди out по ключу.
+	ghosts  map[K]*ListItem[ghost[K]]
+	outSize int64
+}
+
+type ghost[K comparable] struct {
+	key  K
+	cost int64
+}
+
+func newTwoQueues[K comparable, V any](b budget) *twoQueues[K, V] {
+	return &twoQueues[K, V]{
+		budget: b,
+		inMax:  b.share(25),
+		outMax: b.share(50),
+		in:     newQueue[K, V](),
+		main:   newQueue[K, V](),
+		out:    NewListOf[ghost[K]](),
+		ghosts: make(map[K]*ListItem[ghost[K]]),
+	}
+}
+
+func (p *twoQueues[K, V]) add(item *cacheItem[K, V]) {
+	if node, ok := p.ghosts[item.key]; ok {
+		p.forgetGhost(node)
+		item.queue = queueMain
+		p.main.pushFront(item, p.budget.cost(item.weight))
+		return
+	}
+	item.queue = queueIn
+	p.in.pushFront(item, p.budget.cost(item.weight))
+}
+
+// touch переносит в начало только записи main: повторное обращение к записи in
+// вскоре после добавления еще не говорит о том, что она популярна.
+func (p *twoQueues[K, V]) touch(item *cacheItem[K, V]) {
+	if item.queue == queueMain {
+		p.main.list.MoveToFront(item.node)
+	}
+}
+
+func (p *twoQueues[K, V]) remove(item *cacheItem[K, V]) {
+	if item.queue == queueMain {
+		p.main.remove(item, p.budget.cost(item.weight))
+	} else {
+		p.in.remove(item, p.budget.cost(item.weight))
+	}
+}
+
+func (p *twoQueues[K, V]) evict() *cacheItem[K, V] {
+	if p.in.size > p.inMax || p.main.size == 0 {
+		item := p.in.back()
+		cost := p.budget.cost(item.weight)
+		p.in.remove(item, cost)
+		p.addGhost(item.key, cost)
+		return item
+	}
+	item := p.main.back()
+	p.main.remove(item, p.budget.cost(item.weight))
+	return item
+}
+
+func (p *twoQueues[K, V]) addGhost(key K, cost int64) {
+	p.ghosts[key] = p.out.PushFront(ghost[K]{key: key, cost: cost})
+	p.outSize += cost
+	for p.outSize > p.outMax {
+		p.forgetGhost(p.out.Back())
+	}
+}
+
+func (p *twoQueues[K, V]) forgetGhost(node *ListItem[ghost[K]]) {
+	g := node.Value
+	p.out.Remove(node)
+	delete(p.ghosts, g.key)
+	p.outSize -= g.cost
+}
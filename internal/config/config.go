@@ -4,20 +4,166 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Server SrvCfg
-	Cache  CacheCfg
+	Server   SrvCfg
+	Cache    CacheCfg
+	Upstream UpstreamCfg
+	Breaker  BreakerCfg
+	Local    LocalCfg
+	S3       S3Cfg
+	Storage  StorageCfg
+	Admin    AdminCfg
+	Peer     PeerCfg
+	Redis    RedisCfg
 }
 
 type SrvCfg struct {
 	Host string
 	Port string
+	// MaxUploadBytes лимит размера изображения, присланного в POST /fill/{w}/{h}.
+	MaxUploadBytes int64
 }
 
 type CacheCfg struct {
+	// Capacity наибольшее число записей в кэше превью, 0 - без ограничения числа записей.
 	Capacity int
+	// MaxBytes наибольший суммарный вес записей (объем превью на диске), 0 - без ограничения.
+	MaxBytes int64
+	// Shards число независимо блокируемых сегментов кэша, лимиты делятся между ними поровну.
+	// Значение 1 - один сегмент. Сегментов не больше Capacity, а одна запись не может
+	// быть тяжелее MaxBytes/Shards.
+	Shards int
+	// Policy политика вытеснения: lru (по-умолчанию), lfu, 2q или tinylfu.
+	// С другим значением сервис не запускается.
+	Policy string
+	// TTL срок жизни превью в кэше, если источник не указал свой в Cache-Control, 0 - без срока.
+	TTL time.Duration
+	// JanitorInterval период фоновой очистки кэша от устаревших записей, 0 - без очистки
+	// (устаревшие записи удаляются только при обращении к ним).
+	JanitorInterval time.Duration
+	// MemoryMaxBytes объем памяти под байты часто запрашиваемых превью, которые
+	// отдаются без чтения с диска. Значение 0 выключает кэш превью в памяти.
+	MemoryMaxBytes int64
+	// OriginMaxBytes объем памяти под исходные изображения, из которых делаются превью.
+	// Значение 0 выключает кэш исходников.
+	OriginMaxBytes int64
+}
+
+// UpstreamCfg настройки запросов к источникам изображений.
+type UpstreamCfg struct {
+	// Timeout общий срок на получение изображения, включая все повторные попытки.
+	Timeout time.Duration
+	// RetryAttempts число попыток запроса, включая первую.
+	RetryAttempts int
+	// RetryBaseDelay и RetryMaxDelay границы экспоненциальной паузы между попытками.
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// MaxSourceBytes максимальный размер исходного изображения.
+	MaxSourceBytes int64
+	// DefaultTTL срок свежести исходника, если источник не указал Cache-Control или Expires.
+	DefaultTTL time.Duration
+	// StaleWhileRevalidate сколько после устаревания исходника превью отдается сразу,
+	// а исходник перепроверяется в фоне.
+	StaleWhileRevalidate time.Duration
+	// StaleIfError сколько после устаревания исходника можно отдавать превью,
+	// если источник недоступен или отвечает ошибкой 5xx.
+	StaleIfError time.Duration
+}
+
+// BreakerCfg настройки circuit breaker, который ведется отдельно для каждого хоста-источника.
+type BreakerCfg struct {
+	// FailureThreshold число подряд неудачных запросов, после которого хост отключается.
+	// Значение 0 выключает circuit breaker.
+	FailureThreshold int
+	// Cooldown время, на которое хост отключается, прежде чем будут пропущены пробные запросы.
+	Cooldown time.Duration
+	// HalfOpenProbes число одновременных пробных запросов в полуоткрытом состоянии.
+	HalfOpenProbes int
+}
+
+// LocalCfg настройки чтения исходников из локальных каталогов:
+// /fill/300/200/local/{root-name}/path/to/img.jpg.
+type LocalCfg struct {
+	// Roots каталоги по имени root-name.
+	Roots map[string]string
+	// FollowSymlinks разрешает символические ссылки, если они не выводят за пределы каталога.
+	FollowSymlinks bool
+}
+
+// StorageCfg настройки хранения превью на диске.
+type StorageCfg struct {
+	// Path каталог с файлами превью.
+	Path string
+	// WipeOnShutdown удаляет все превью при остановке сервиса. По-умолчанию превью
+	// сохраняются, и кэш восстанавливается из каталога при запуске.
+	WipeOnShutdown bool
+}
+
+// AdminCfg настройки административного API кэша. Оно слушает отдельный адрес,
+// а запросы к нему должны содержать заголовок Authorization: Bearer {Token}.
+type AdminCfg struct {
+	// Addr адрес административного API, например 127.0.0.1:8001. Пустое значение выключает API.
+	Addr string
+	// Token токен доступа. Без токена административное API не запускается.
+	Token string
+}
+
+// PeerCfg настройки распределения превью между экземплярами сервиса: каждое превью
+// принадлежит одному экземпляру, остальные запрашивают его у владельца.
+type PeerCfg struct {
+	// Self адрес этого экземпляра в том виде, в каком он указан в Peers.
+	Self string
+	// Peers адреса всех экземпляров, включая этот, например http://10.0.0.1:8000.
+	// Пустой список выключает распределение.
+	Peers []string
+	// Replicas число точек каждого экземпляра на кольце консистентного хэширования.
+	Replicas int
+	// Timeout срок на получение превью у владельца, включая загрузку исходника владельцем.
+	// Срок записи ответа сервера превью увеличивается так, чтобы после него хватило
+	// Upstream.Timeout на загрузку исходника здесь.
+	Timeout time.Duration
+	// Token общий токен экземпляров, без него /peer/fill/ не отвечает.
+	Token string
+}
+
+// DefaultRedisPrefix префикс ключей превью в общем хранилище, если REDIS_PREFIX не задан.
+const DefaultRedisPrefix = "previewer:"
+
+// RedisCfg настройки общего для экземпляров сервиса хранилища превью,
+// совместимого с протоколом Redis (RESP).
+type RedisCfg struct {
+	// Addr адрес хранилища host:port. Пустое значение выключает общее хранилище.
+	Addr     string
+	Password string
+	DB       int
+	// Prefix префикс ключей превью, чтобы не пересекаться с другими данными в той же базе.
+	// Пустой префикс заменяется на DefaultRedisPrefix.
+	Prefix string
+	// Timeout срок на одну команду, включая установку соединения.
+	Timeout time.Duration
+	// RetryInterval сколько после ошибки хранилище не используется, превью берутся только из локального кэша.
+	RetryInterval time.Duration
+	// PoolSize наибольшее число простаивающих соединений.
+	PoolSize int
+}
+
+// S3Cfg настройки S3-совместимого хранилища исходников:
+// /fill/300/200/s3/{bucket}/path/to/img.jpg.
+type S3Cfg struct {
+	// Endpoint адрес хранилища, например https://s3.eu-central-1.amazonaws.com
+	// или http://minio:9000. Пустое значение выключает этот источник.
+	Endpoint     string
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// PathStyle адресация бакета в пути (endpoint/bucket/key), нужна для MinIO.
+	// Иначе бакет указывается в имени хоста (bucket.endpoint/key).
+	PathStyle bool
 }
 
 func New() Config {
@@ -30,23 +176,164 @@ func New() Config {
 		Port = ":8000"
 	}
 	server := SrvCfg{
-		Host: Host,
-		Port: Port,
+		Host:           Host,
+		Port:           Port,
+		MaxUploadBytes: envInt64("MAX_UPLOAD_BYTES", 10<<20),
 	}
 
+	cacheMaxBytes := envInt64("CACHE_MAX_BYTES", 0)
 	cacheCapStr := os.Getenv("CACHE_CAPACITY")
 	cacheCapInt, err := strconv.Atoi(cacheCapStr)
 	if err != nil {
+		// если кэш ограничен по объему, число записей по-умолчанию не ограничиваем
 		cacheCapInt = 3
+		if cacheMaxBytes > 0 {
+			cacheCapInt = 0
+		}
 		log.Printf("can't get cache cap, set to default = %d \n", cacheCapInt)
 	}
 
 	cache := CacheCfg{
-		Capacity: cacheCapInt,
+		Capacity:        cacheCapInt,
+		MaxBytes:        cacheMaxBytes,
+		Shards:          envInt("CACHE_SHARDS", 1),
+		Policy:          strings.ToLower(strings.TrimSpace(os.Getenv("CACHE_POLICY"))),
+		TTL:             envDuration("CACHE_TTL", 7*24*time.Hour),
+		JanitorInterval: envDuration("CACHE_JANITOR_INTERVAL", time.Minute),
+		MemoryMaxBytes:  envInt64("CACHE_MEMORY_MAX_BYTES", 16<<20),
+		OriginMaxBytes:  envInt64("ORIGIN_CACHE_MAX_BYTES", 64<<20),
+	}
+
+	upstream := UpstreamCfg{
+		Timeout:        envDuration("UPSTREAM_TIMEOUT", 8*time.Second),
+		RetryAttempts:  envInt("RETRY_ATTEMPTS", 3),
+		RetryBaseDelay: envDuration("RETRY_BASE_DELAY", 100*time.Millisecond),
+		RetryMaxDelay:  envDuration("RETRY_MAX_DELAY", 2*time.Second),
+		MaxSourceBytes: envInt64("MAX_SOURCE_BYTES", 100<<20),
+		DefaultTTL:     envDuration("SOURCE_DEFAULT_TTL", time.Hour),
+
+		StaleWhileRevalidate: envDuration("STALE_WHILE_REVALIDATE", time.Minute),
+		StaleIfError:         envDuration("STALE_IF_ERROR", time.Hour),
+	}
+
+	breaker := BreakerCfg{
+		FailureThreshold: envInt("BREAKER_FAILURES", 5),
+		Cooldown:         envDuration("BREAKER_COOLDOWN", 30*time.Second),
+		HalfOpenProbes:   envInt("BREAKER_HALF_OPEN_PROBES", 1),
+	}
+
+	local := LocalCfg{
+		Roots:          envMap("LOCAL_ROOTS"),
+		FollowSymlinks: os.Getenv("LOCAL_FOLLOW_SYMLINKS") == "true",
+	}
+
+	s3 := S3Cfg{
+		Endpoint:     os.Getenv("S3_ENDPOINT"),
+		Region:       os.Getenv("S3_REGION"),
+		AccessKey:    os.Getenv("S3_ACCESS_KEY"),
+		SecretKey:    os.Getenv("S3_SECRET_KEY"),
+		SessionToken: os.Getenv("S3_SESSION_TOKEN"),
+		PathStyle:    os.Getenv("S3_PATH_STYLE") == "true",
+	}
+
+	storage := StorageCfg{
+		Path:           os.Getenv("STORAGE_PATH"),
+		WipeOnShutdown: os.Getenv("STORAGE_WIPE_ON_SHUTDOWN") == "true",
+	}
+	if storage.Path == "" {
+		storage.Path = "./internal/storage/"
+	}
+
+	admin := AdminCfg{
+		Addr:  os.Getenv("ADMIN_ADDR"),
+		Token: os.Getenv("ADMIN_TOKEN"),
+	}
+
+	peer := PeerCfg{
+		Self:     os.Getenv("PEER_SELF"),
+		Peers:    envList("PEERS"),
+		Replicas: envInt("PEER_REPLICAS", 50),
+		Timeout:  envDuration("PEER_TIMEOUT", 10*time.Second),
+		Token:    os.Getenv("PEER_TOKEN"),
+	}
+
+	redis := RedisCfg{
+		Addr:          os.Getenv("REDIS_ADDR"),
+		Password:      os.Getenv("REDIS_PASSWORD"),
+		DB:            envInt("REDIS_DB", 0),
+		Prefix:        os.Getenv("REDIS_PREFIX"),
+		Timeout:       envDuration("REDIS_TIMEOUT", 500*time.Millisecond),
+		RetryInterval: envDuration("REDIS_RETRY_INTERVAL", 5*time.Second),
+		PoolSize:      envInt("REDIS_POOL_SIZE", 8),
+	}
+	if redis.Prefix == "" {
+		redis.Prefix = DefaultRedisPrefix
 	}
 
 	return Config{
-		Server: server,
-		Cache:  cache,
+		Server:   server,
+		Cache:    cache,
+		Upstream: upstream,
+		Breaker:  breaker,
+		Local:    local,
+		S3:       s3,
+		Storage:  storage,
+		Admin:    admin,
+		Peer:     peer,
+		Redis:    redis,
+	}
+}
+
+// envInt читает целое число из переменной окружения, при ошибке возвращает значение по умолчанию.
+func envInt(name string, def int) int {
+	val, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		log.Printf("can't get %s, set to default = %d \n", name, def)
+		return def
+	}
+	return val
+}
+
+// envInt64 читает целое число (например, объем в байтах) из переменной окружения.
+func envInt64(name string, def int64) int64 {
+	val, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil {
+		log.Printf("can't get %s, set to default = %d \n", name, def)
+		return def
+	}
+	return val
+}
+
+// envMap читает из переменной окружения пары вида "name=value,name2=value2".
+func envMap(name string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv(name), ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || key == "" || value == "" {
+			continue
+		}
+		result[key] = value
+	}
+	return result
+}
+
+// envList читает из переменной окружения список значений через запятую.
+func envList(name string) []string {
+	var result []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// envDuration читает длительность (например "500ms", "2s") из переменной окружения.
+func envDuration(name string, def time.Duration) time.Duration {
+	val, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		log.Printf("can't get %s, set to default = %s \n", name, def)
+		return def
 	}
+	return val
 }
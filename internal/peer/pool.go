@@ -0,0 +1,124 @@
+package peer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/app"
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+)
+
+// PathPrefix префикс внутреннего адреса, по которому экземпляр отдает превью, принадлежащие ему:
+// /peer/fill/{width}/{height}/{source}. Владелец делает превью сам, не обращаясь к другим экземплярам.
+const PathPrefix = "/peer"
+
+var ErrNotConfigured = errors.New("peers are not configured")
+
+// Pool экземпляры сервиса, между которыми превью распределены по ключу, и клиент к ним.
+type Pool struct {
+	self   string
+	ring   *Ring
+	client *http.Client
+	token  string
+}
+
+// NewPool создает пул экземпляров. Если экземпляры не заданы, возвращает ErrNotConfigured.
+// Адрес этого экземпляра должен входить в список, иначе ему не будет принадлежать ни одно превью.
+// Без общего токена экземпляры не примут запросы друг друга, поэтому он обязателен.
+func NewPool(cfg config.PeerCfg) (*Pool, error) {
+	if len(cfg.Peers) == 0 {
+		return nil, ErrNotConfigured
+	}
+	if cfg.Token == "" {
+		return nil, errors.New("peer token is not set")
+	}
+	peers := make([]string, 0, len(cfg.Peers))
+	for _, peer := range cfg.Peers {
+		u, err := url.Parse(peer)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("wrong peer address %q", peer)
+		}
+		peers = append(peers, strings.TrimRight(peer, "/"))
+	}
+	self := strings.TrimRight(cfg.Self, "/")
+	if !slices.Contains(peers, self) {
+		return nil, fmt.Errorf("peer self address %q is not in peers list", cfg.Self)
+	}
+	return &Pool{
+		self:   self,
+		ring:   NewRing(cfg.Replicas, peers...),
+		client: &http.Client{Timeout: cfg.Timeout},
+		token:  cfg.Token,
+	}, nil
+}
+
+// Owner возвращает экземпляр, которому принадлежит ключ, и true, если это другой экземпляр.
+func (p *Pool) Owner(key string) (string, bool) {
+	owner := p.ring.Owner(key)
+	return owner, owner != p.self
+}
+
+// Fetch получает у экземпляра peer превью по ключу key, заголовки запроса передаются владельцу
+// для запроса к источнику, кроме Authorization: в нем передается общий токен экземпляров
+// (учетные данные клиента источнику все равно не передаются). Ответ владельца с ошибкой (4xx и 5xx, например, недоступен источник)
+// возвращается как *app.PreviewError: повторять загрузку здесь незачем. Остальные ошибки
+// (соединение, чтение ответа) означают, что владелец недоступен.
+func (p *Pool) Fetch(ctx context.Context, peer, key string, header http.Header) (*app.Preview, error) {
+	u, err := url.Parse(peer)
+	if err != nil {
+		return nil, fmt.Errorf("wrong peer address %q: %w", peer, err)
+	}
+	u.Path = PathPrefix + key
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create peer request: %w", err)
+	}
+	for name, values := range header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	// ответ разбирается сам, сжатие не нужно
+	req.Header.Del("Accept-Encoding")
+	req.Header.Set("Authorization", "Bearer "+p.token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("peer request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read peer response: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return &app.Preview{
+			Data:      data,
+			FromCache: resp.Header.Get("Get_from_cache") == "1",
+			Freshness: resp.Header.Get("Freshness"),
+		}, nil
+	case resp.StatusCode >= 400:
+		var body struct {
+			Error   string `json:"error"`
+			Details string `json:"details"`
+		}
+		json.Unmarshal(data, &body) //nolint:errcheck
+		return nil, &app.PreviewError{
+			Status:  resp.StatusCode,
+			Details: body.Details,
+			Err:     fmt.Errorf("peer %s: %s", peer, body.Error),
+		}
+	default:
+		return nil, fmt.Errorf("peer %s responded with status %d", peer, resp.StatusCode)
+	}
+}
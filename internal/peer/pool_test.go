@@ -0,0 +1,81 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/app"
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPool(t *testing.T) {
+	_, err := NewPool(config.PeerCfg{})
+	require.ErrorIs(t, err, ErrNotConfigured)
+
+	_, err = NewPool(config.PeerCfg{Self: "http://a:8000", Peers: []string{"a:8000"}, Token: "t"})
+	require.Error(t, err)
+
+	_, err = NewPool(config.PeerCfg{Self: "http://c:8000", Peers: []string{"http://a:8000", "http://b:8000"}, Token: "t"})
+	require.Error(t, err)
+
+	_, err = NewPool(config.PeerCfg{Self: "http://a:8000", Peers: []string{"http://a:8000", "http://b:8000"}})
+	require.Error(t, err)
+
+	pool, err := NewPool(config.PeerCfg{Self: "http://a:8000/", Peers: []string{"http://a:8000", "http://b:8000/"}, Token: "t"})
+	require.NoError(t, err)
+	for _, key := range []string{"/fill/1/1/a.jpg", "/fill/1/1/b.jpg", "/fill/1/1/c.jpg", "/fill/1/1/d.jpg"} {
+		owner, isRemote := pool.Owner(key)
+		require.Contains(t, []string{"http://a:8000", "http://b:8000"}, owner)
+		require.Equal(t, owner == "http://b:8000", isRemote)
+	}
+}
+
+func TestPoolFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer peer-token", r.Header.Get("Authorization"))
+		require.Equal(t, "ru", r.Header.Get("Accept-Language"))
+		switch r.URL.Path {
+		case PathPrefix + "/fill/10/10/example.com/a b.jpg":
+			w.Header().Set("Get_from_cache", "1")
+			w.Header().Set("Freshness", app.Fresh)
+			w.Write([]byte("preview"))
+		case PathPrefix + "/fill/10/10/example.com/missing.jpg":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"not found","details":"fail fetch data request"}`))
+		case PathPrefix + "/fill/10/10/example.com/down.jpg":
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`{"error":"upstream is down","details":"fail fetch data request"}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	pool, err := NewPool(config.PeerCfg{Self: "http://self:8000", Peers: []string{"http://self:8000", srv.URL}, Token: "peer-token"})
+	require.NoError(t, err)
+	header := http.Header{"Authorization": {"client secret"}, "Accept-Language": {"ru"}}
+
+	preview, err := pool.Fetch(context.Background(), srv.URL, "/fill/10/10/example.com/a b.jpg", header)
+	require.NoError(t, err)
+	require.Equal(t, &app.Preview{Data: []byte("preview"), FromCache: true, Freshness: app.Fresh}, preview)
+
+	_, err = pool.Fetch(context.Background(), srv.URL, "/fill/10/10/example.com/missing.jpg", header)
+	var pErr *app.PreviewError
+	require.True(t, errors.As(err, &pErr))
+	require.Equal(t, http.StatusNotFound, pErr.Status)
+	require.Equal(t, "fail fetch data request", pErr.Details)
+
+	// ошибка источника у владельца - тоже ответ владельца, а не его недоступность
+	_, err = pool.Fetch(context.Background(), srv.URL, "/fill/10/10/example.com/down.jpg", header)
+	require.True(t, errors.As(err, &pErr))
+	require.Equal(t, http.StatusBadGateway, pErr.Status)
+
+	srv.Close()
+	_, err = pool.Fetch(context.Background(), srv.URL, "/fill/10/10/example.com/a b.jpg", header)
+	require.Error(t, err)
+	require.False(t, errors.As(err, &pErr))
+}
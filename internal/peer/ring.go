@@ -0,0 +1,65 @@
+package peer
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// Ring консистентное хэширование ключей по экземплярам: каждый экземпляр занимает
+// replicas точек на кольце, а ключ принадлежит экземпляру первой точки после хэша ключа.
+// При добавлении или удалении экземпляра владельца меняют только ключи его участков кольца.
+type Ring struct {
+	// hashes точки кольца по возрастанию, owners - экземпляры по точкам.
+	hashes []uint64
+	owners map[uint64]string
+}
+
+// NewRing строит кольцо из экземпляров nodes, у каждого replicas точек (не меньше одной).
+func NewRing(replicas int, nodes ...string) *Ring {
+	replicas = max(replicas, 1)
+	r := &Ring{
+		hashes: make([]uint64, 0, replicas*len(nodes)),
+		owners: make(map[uint64]string, replicas*len(nodes)),
+	}
+	for _, node := range nodes {
+		for i := 0; i < replicas; i++ {
+			h := hash(strconv.Itoa(i) + "#" + node)
+			// совпадение точек разных экземпляров маловероятно, первый экземпляр сохраняет точку
+			if _, ok := r.owners[h]; ok {
+				continue
+			}
+			r.owners[h] = node
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// Owner экземпляр, которому принадлежит ключ, пустая строка - кольцо пусто.
+func (r *Ring) Owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// hash FNV-1a с перемешиванием битов (финализатор splitmix64): у FNV близкие строки,
+// например номера точек одного экземпляра, дают близкие хэши и ложатся на кольцо кучно.
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
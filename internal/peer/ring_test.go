@@ -0,0 +1,42 @@
+package peer
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRing(t *testing.T) {
+	require.Empty(t, NewRing(10).Owner("key"))
+
+	nodes := []string{"http://a:8000", "http://b:8000", "http://c:8000"}
+	ring := NewRing(50, nodes...)
+
+	const keys = 30000
+	owners := make(map[string]string, keys)
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		key := "/fill/300/200/example.com/" + strconv.Itoa(i) + ".jpg"
+		owner := ring.Owner(key)
+		require.Equal(t, owner, ring.Owner(key))
+		owners[key] = owner
+		counts[owner]++
+	}
+	// ключи распределены между всеми экземплярами без сильного перекоса
+	require.Len(t, counts, len(nodes))
+	for node, count := range counts {
+		require.InDelta(t, keys/len(nodes), count, float64(keys/len(nodes)/3), node)
+	}
+
+	// новый экземпляр забирает часть ключей, остальные ключи владельца не меняют
+	grown := NewRing(50, append(nodes, "http://d:8000")...)
+	moved := 0
+	for key, owner := range owners {
+		if newOwner := grown.Owner(key); newOwner != owner {
+			require.Equal(t, "http://d:8000", newOwner)
+			moved++
+		}
+	}
+	require.InDelta(t, keys/4, moved, float64(keys/4/2))
+}
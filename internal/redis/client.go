@@ -0,0 +1,228 @@
+// Package redis минимальный клиент хранилищ, совместимых с протоколом Redis (RESP2):
+// только команды, нужные для общего кэша превью.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+)
+
+var ErrNotConfigured = errors.New("redis is not configured")
+
+// scanCount сколько ключей просить у хранилища за один шаг SCAN.
+const scanCount = "1000"
+
+// Client отправляет команды хранилищу по соединениям из пула.
+// Соединение, на котором произошла сетевая ошибка, закрывается и в пул не возвращается.
+type Client struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	// idle простаивающие соединения.
+	idle chan *conn
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// NewClient создает клиента. Если адрес не задан, возвращает ErrNotConfigured.
+// Соединения устанавливаются при первых командах.
+func NewClient(cfg config.RedisCfg) (*Client, error) {
+	if cfg.Addr == "" {
+		return nil, ErrNotConfigured
+	}
+	return &Client{
+		addr:     cfg.Addr,
+		password: cfg.Password,
+		db:       cfg.DB,
+		timeout:  cfg.Timeout,
+		idle:     make(chan *conn, max(cfg.PoolSize, 1)),
+	}, nil
+}
+
+// Do отправляет команду и возвращает ответ (см. ReadValue). Ответ-ошибка возвращается как Error.
+func (c *Client) Do(ctx context.Context, args ...[]byte) (interface{}, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	value, err := cn.do(ctx, args...)
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	if e, ok := value.(Error); ok {
+		return nil, e
+	}
+	return value, nil
+}
+
+// Get возвращает значение ключа, false - ключа нет.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := c.Do(ctx, []byte("GET"), []byte(key))
+	if err != nil || value == nil {
+		return nil, false, err
+	}
+	data, ok := value.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected GET reply %T", value)
+	}
+	return data, true, nil
+}
+
+// Set сохраняет значение ключа на срок ttl, 0 - без срока.
+func (c *Client) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := [][]byte{[]byte("SET"), []byte(key), value}
+	if ttl > 0 {
+		args = append(args, []byte("PX"), []byte(strconv.FormatInt(max(ttl.Milliseconds(), 1), 10)))
+	}
+	_, err := c.Do(ctx, args...)
+	return err
+}
+
+// Del удаляет ключи и возвращает число удаленных.
+func (c *Client) Del(ctx context.Context, keys ...string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	args := make([][]byte, 0, len(keys)+1)
+	args = append(args, []byte("DEL"))
+	for _, key := range keys {
+		args = append(args, []byte(key))
+	}
+	value, err := c.Do(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := value.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected DEL reply %T", value)
+	}
+	return int(n), nil
+}
+
+// Scan возвращает ключи, подходящие под шаблон match (например, "prefix:*").
+// Ключи, добавленные или удаленные во время обхода, могут быть пропущены.
+func (c *Client) Scan(ctx context.Context, match string) ([]string, error) {
+	var keys []string
+	cursor := []byte("0")
+	for {
+		value, err := c.Do(ctx, []byte("SCAN"), cursor, []byte("MATCH"), []byte(match), []byte("COUNT"), []byte(scanCount))
+		if err != nil {
+			return nil, err
+		}
+		reply, ok := value.([]interface{})
+		if !ok || len(reply) != 2 {
+			return nil, fmt.Errorf("unexpected SCAN reply %T", value)
+		}
+		next, ok := reply[0].([]byte)
+		batch, ok2 := reply[1].([]interface{})
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("unexpected SCAN reply")
+		}
+		for _, key := range batch {
+			if key, ok := key.([]byte); ok {
+				keys = append(keys, string(key))
+			}
+		}
+		if string(next) == "0" {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+// Close закрывает простаивающие соединения. Соединения занятых команд закрываются по их завершении.
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// get берет соединение из пула или устанавливает новое.
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("connect to redis: %w", err)
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if err := c.init(ctx, cn); err != nil {
+		cn.Close()
+		return nil, err
+	}
+	return cn, nil
+}
+
+// init авторизует новое соединение и выбирает базу.
+func (c *Client) init(ctx context.Context, cn *conn) error {
+	var commands [][][]byte
+	if c.password != "" {
+		commands = append(commands, [][]byte{[]byte("AUTH"), []byte(c.password)})
+	}
+	if c.db != 0 {
+		commands = append(commands, [][]byte{[]byte("SELECT"), []byte(strconv.Itoa(c.db))})
+	}
+	for _, args := range commands {
+		value, err := cn.do(ctx, args...)
+		if err != nil {
+			return err
+		}
+		if e, ok := value.(Error); ok {
+			return fmt.Errorf("redis %s: %w", args[0], e)
+		}
+	}
+	return nil
+}
+
+// put возвращает соединение в пул, лишнее закрывается.
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+func (cn *conn) do(ctx context.Context, args ...[]byte) (interface{}, error) {
+	deadline, _ := ctx.Deadline()
+	if err := cn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if err := WriteCommand(cn.w, args...); err != nil {
+		return nil, fmt.Errorf("write redis command: %w", err)
+	}
+	value, err := ReadValue(cn.r)
+	if err != nil {
+		return nil, fmt.Errorf("read redis reply: %w", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,80 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/Ser9unin/ImagePreviewer/internal/redis"
+	"github.com/Ser9unin/ImagePreviewer/internal/redis/redistest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	_, err := redis.NewClient(config.RedisCfg{})
+	require.ErrorIs(t, err, redis.ErrNotConfigured)
+
+	srv := redistest.NewServer()
+	srv.SetPassword("secret")
+	defer srv.Close()
+
+	ctx := context.Background()
+	client, err := redis.NewClient(config.RedisCfg{Addr: srv.Addr(), Password: "secret", DB: 1, Timeout: time.Second, PoolSize: 2})
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, ok, err := client.Get(ctx, "missing")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// значения передаются как есть, включая переводы строк и нулевые байты
+	value := []byte("line\r\nwith\x00zero")
+	require.NoError(t, client.Set(ctx, "p:a", value, 0))
+	require.NoError(t, client.Set(ctx, "p:b", []byte("b"), time.Hour))
+	require.NoError(t, client.Set(ctx, "other", []byte("c"), 0))
+	data, ok, err := client.Get(ctx, "p:a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, value, data)
+	require.Zero(t, srv.TTL("p:a"))
+	require.InDelta(t, time.Hour.Seconds(), srv.TTL("p:b").Seconds(), 5)
+
+	keys, err := client.Scan(ctx, "p:*")
+	require.NoError(t, err)
+	sort.Strings(keys)
+	require.Equal(t, []string{"p:a", "p:b"}, keys)
+
+	n, err := client.Del(ctx, "p:a", "missing")
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	// ответ-ошибка не ломает соединение
+	_, err = client.Do(ctx, []byte("UNKNOWN"))
+	var rErr redis.Error
+	require.True(t, errors.As(err, &rErr))
+	require.NoError(t, client.Set(ctx, "p:c", []byte("c"), 0))
+
+	// без пароля команды отклоняются
+	anonymous, err := redis.NewClient(config.RedisCfg{Addr: srv.Addr(), Timeout: time.Second})
+	require.NoError(t, err)
+	_, _, err = anonymous.Get(ctx, "p:b")
+	require.ErrorAs(t, err, &rErr)
+}
+
+func TestClientUnavailable(t *testing.T) {
+	srv := redistest.NewServer()
+	client, err := redis.NewClient(config.RedisCfg{Addr: srv.Addr(), Timeout: time.Second})
+	require.NoError(t, err)
+	require.NoError(t, client.Set(context.Background(), "a", []byte("a"), 0))
+
+	// соединение из пула оказывается закрытым, ошибка возвращается, а следующая команда
+	// устанавливает новое соединение и тоже получает ошибку
+	srv.Close()
+	_, _, err = client.Get(context.Background(), "a")
+	require.Error(t, err)
+	_, _, err = client.Get(context.Background(), "a")
+	require.Error(t, err)
+}
@@ -0,0 +1,297 @@
+// Package redistest поддельное хранилище с протоколом Redis для тестов,
+// по аналогии с net/http/httptest.
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/redis"
+)
+
+type item struct {
+	value   []byte
+	expires time.Time
+}
+
+// Server хранит ключи в памяти и отвечает на PING, AUTH, SELECT, GET, SET (с EX и PX),
+// DEL, SCAN (весь ответ за один шаг) и FLUSHDB. Шаблоны SCAN MATCH поддерживают "*", "?"
+// и экранирование "\\".
+type Server struct {
+	listener net.Listener
+	mu       sync.Mutex
+	password string
+	items    map[string]item
+	commands int
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+// NewServer запускает сервер на случайном порту localhost.
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("redistest: failed to listen: " + err.Error())
+	}
+	s := &Server{listener: listener, items: make(map[string]item), conns: make(map[net.Conn]struct{})}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// SetPassword требует передать пароль в AUTH до остальных команд, пустой - без пароля.
+func (s *Server) SetPassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+// Addr адрес сервера host:port.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close останавливает сервер и закрывает соединения.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Commands число выполненных команд.
+func (s *Server) Commands() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands
+}
+
+// Keys ключи, срок которых не истек, по возрастанию.
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.items))
+	for key := range s.items {
+		if _, ok := s.lookup(key); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TTL оставшийся срок ключа, 0 - без срока.
+func (s *Server) TTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, ok := s.lookup(key)
+	if !ok || it.expires.IsZero() {
+		return 0
+	}
+	return time.Until(it.expires)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	s.mu.Lock()
+	authorized := s.password == ""
+	s.mu.Unlock()
+	for {
+		value, err := redis.ReadValue(r)
+		if err != nil {
+			return
+		}
+		args, ok := value.([]interface{})
+		if !ok || len(args) == 0 {
+			return
+		}
+		cmd := make([]string, len(args))
+		for i, arg := range args {
+			b, _ := arg.([]byte)
+			cmd[i] = string(b)
+		}
+		name := strings.ToUpper(cmd[0])
+		if name == "AUTH" && len(cmd) == 2 {
+			s.mu.Lock()
+			authorized = cmd[1] == s.password
+			s.mu.Unlock()
+		}
+		if !authorized && name != "AUTH" {
+			writeValue(w, redis.Error("NOAUTH Authentication required."))
+		} else {
+			writeValue(w, s.exec(name, cmd[1:]))
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+var errSyntax = redis.Error("ERR syntax error")
+
+func (s *Server) exec(name string, args []string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands++
+
+	switch {
+	case name == "PING":
+		return "PONG"
+	case name == "AUTH" && len(args) == 1:
+		if args[0] != s.password {
+			return redis.Error("WRONGPASS invalid password")
+		}
+		return "OK"
+	case name == "SELECT" && len(args) == 1:
+		return "OK"
+	case name == "GET" && len(args) == 1:
+		if it, ok := s.lookup(args[0]); ok {
+			return it.value
+		}
+		return nil
+	case name == "SET" && (len(args) == 2 || len(args) == 4):
+		it := item{value: []byte(args[1])}
+		if len(args) == 4 {
+			n, err := strconv.ParseInt(args[3], 10, 64)
+			if err != nil || n <= 0 {
+				return redis.Error("ERR invalid expire time in 'set' command")
+			}
+			switch strings.ToUpper(args[2]) {
+			case "PX":
+				it.expires = time.Now().Add(time.Duration(n) * time.Millisecond)
+			case "EX":
+				it.expires = time.Now().Add(time.Duration(n) * time.Second)
+			default:
+				return errSyntax
+			}
+		}
+		s.items[args[0]] = it
+		return "OK"
+	case name == "DEL" && len(args) > 0:
+		var n int64
+		for _, key := range args {
+			if _, ok := s.lookup(key); ok {
+				delete(s.items, key)
+				n++
+			}
+		}
+		return n
+	case name == "SCAN" && len(args) >= 1:
+		match := "*"
+		for i := 1; i+1 < len(args); i += 2 {
+			if strings.EqualFold(args[i], "MATCH") {
+				match = args[i+1]
+			}
+		}
+		var keys []interface{}
+		for key := range s.items {
+			if _, ok := s.lookup(key); ok && matches(match, key) {
+				keys = append(keys, []byte(key))
+			}
+		}
+		return []interface{}{[]byte("0"), keys}
+	case name == "FLUSHDB":
+		s.items = make(map[string]item)
+		return "OK"
+	default:
+		return redis.Error("ERR unknown command or wrong number of arguments for '" + strings.ToLower(name) + "'")
+	}
+}
+
+// lookup возвращает ключ, удаляя его, если срок истек. Вызывается под блокировкой.
+func (s *Server) lookup(key string) (item, bool) {
+	it, ok := s.items[key]
+	if ok && !it.expires.IsZero() && !time.Now().Before(it.expires) {
+		delete(s.items, key)
+		return item{}, false
+	}
+	return it, ok
+}
+
+// matches сопоставляет ключ с шаблоном: "*" - любая последовательность символов,
+// "?" - любой символ, "\\" экранирует следующий символ.
+func matches(pattern, key string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			for i := len(key); i >= 0; i-- {
+				if matches(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if key == "" {
+				return false
+			}
+			pattern, key = pattern[1:], key[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+		}
+		if key == "" || key[0] != pattern[0] {
+			return false
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return key == ""
+}
+
+// writeValue пишет ответ в формате RESP.
+func writeValue(w *bufio.Writer, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		w.WriteString("+" + v + "\r\n")
+	case redis.Error:
+		w.WriteString("-" + string(v) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case []byte:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n")
+		w.Write(v)
+		w.WriteString("\r\n")
+	case []interface{}:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, elem := range v {
+			writeValue(w, elem)
+		}
+	default:
+		panic(errors.New("redistest: unsupported reply type"))
+	}
+}
@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// maxBulkLen наибольшая длина строки в ответе, как у Redis (proto-max-bulk-len).
+const maxBulkLen = 512 << 20
+
+// Error ответ-ошибка хранилища (-ERR ...). Соединение после нее остается рабочим.
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+var errProtocol = errors.New("redis protocol error")
+
+// WriteCommand пишет команду в виде массива строк RESP: *2\r\n$3\r\nGET\r\n$3\r\nkey\r\n.
+func WriteCommand(w *bufio.Writer, args ...[]byte) error {
+	w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		w.Write(arg)
+		w.WriteString("\r\n")
+	}
+	return w.Flush()
+}
+
+// ReadValue читает значение RESP: простая строка - string, ошибка - Error,
+// целое - int64, строка - []byte, массив - []interface{}, пустые строка и массив - nil.
+func ReadValue(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("%w: empty line", errProtocol)
+	}
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: wrong integer %q", errProtocol, line)
+		}
+		return n, nil
+	case '$':
+		n, err := readLen(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		if n > maxBulkLen {
+			return nil, fmt.Errorf("%w: bulk string of %d bytes", errProtocol, n)
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		if data[n] != '\r' || data[n+1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk string without CRLF", errProtocol)
+		}
+		return data[:n], nil
+	case '*':
+		n, err := readLen(line)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = ReadValue(r); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", errProtocol, line[0])
+	}
+}
+
+// readLine читает строку до CRLF без него.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return nil, fmt.Errorf("%w: line too long", errProtocol)
+		}
+		return nil, err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("%w: line without CRLF", errProtocol)
+	}
+	return line[:len(line)-2], nil
+}
+
+// readLen разбирает длину строки или массива, -1 - пустое значение (nil).
+func readLen(line []byte) (int, error) {
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < -1 {
+		return 0, fmt.Errorf("%w: wrong length %q", errProtocol, line)
+	}
+	return n, nil
+}
@@ -0,0 +1,75 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+)
+
+var ErrNotConfigured = errors.New("s3 source is not configured")
+
+// Client формирует запросы к S3-совместимому хранилищу (AWS S3, MinIO и т.п.).
+// Сам запросы не отправляет: их отправляет приложение со своими повторами и circuit breaker.
+type Client struct {
+	endpoint  *url.URL
+	region    string
+	creds     Credentials
+	pathStyle bool
+	now       func() time.Time
+}
+
+// NewClient создает клиента. Если endpoint не задан, возвращает ErrNotConfigured.
+func NewClient(cfg config.S3Cfg) (*Client, error) {
+	if cfg.Endpoint == "" {
+		return nil, ErrNotConfigured
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("wrong s3 endpoint %q", cfg.Endpoint)
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &Client{
+		endpoint:  endpoint,
+		region:    region,
+		creds:     Credentials{AccessKey: cfg.AccessKey, SecretKey: cfg.SecretKey, SessionToken: cfg.SessionToken},
+		pathStyle: cfg.PathStyle,
+		now:       time.Now,
+	}, nil
+}
+
+// NewGetObjectRequest формирует подписанный запрос GetObject.
+// Без ключей доступа запрос не подписывается (публичные бакеты).
+func (c *Client) NewGetObjectRequest(ctx context.Context, bucket, key string) (*http.Request, error) {
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("bucket and key are required")
+	}
+
+	u := *c.endpoint
+	basePath := strings.TrimSuffix(u.Path, "/")
+	if c.pathStyle {
+		u.Path = basePath + "/" + bucket + "/" + key
+	} else {
+		u.Host = bucket + "." + u.Host
+		u.Path = basePath + "/" + key
+	}
+	// отправляем путь ровно в том виде, в каком он входит в подпись
+	u.RawPath = encodePath(u.Path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	if c.creds.AccessKey != "" {
+		Sign(req, c.creds, c.region, c.now())
+	}
+	return req, nil
+}
@@ -0,0 +1,120 @@
+// Package s3test поддельное S3-совместимое хранилище для тестов,
+// по аналогии с net/http/httptest.
+package s3test
+
+import (
+	"crypto/md5" //nolint:gosec
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/s3"
+)
+
+type object struct {
+	data        []byte
+	contentType string
+	etag        string
+}
+
+// Server отдает объекты по GetObject с адресацией бакета в пути (path-style),
+// проверяет подпись SigV4 и отвечает 304 на If-None-Match.
+type Server struct {
+	*httptest.Server
+	Region string
+	Creds  s3.Credentials
+
+	mu       sync.Mutex
+	objects  map[string]object
+	requests int
+}
+
+func NewServer(creds s3.Credentials, region string) *Server {
+	s := &Server{Region: region, Creds: creds, objects: make(map[string]object)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Put кладет объект в бакет.
+func (s *Server) Put(bucket, key string, data []byte, contentType string) {
+	sum := md5.Sum(data) //nolint:gosec
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[bucket+"/"+key] = object{data: data, contentType: contentType, etag: `"` + hex.EncodeToString(sum[:]) + `"`}
+}
+
+// Requests число запросов, дошедших до хранилища.
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	obj, ok := s.objects[strings.TrimPrefix(r.URL.Path, "/")]
+	s.mu.Unlock()
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.verify(r) {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+
+	w.Header().Set("ETag", obj.etag)
+	if r.Header.Get("If-None-Match") == obj.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", obj.contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(obj.data)
+}
+
+// verify пересчитывает подпись по подписанным заголовкам запроса.
+func (s *Server) verify(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if s.Creds.AccessKey == "" {
+		return true
+	}
+	_, signed, ok := strings.Cut(auth, "SignedHeaders=")
+	if !ok {
+		return false
+	}
+	signed, _, _ = strings.Cut(signed, ",")
+
+	date, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+
+	req := r.Clone(r.Context())
+	req.Header = http.Header{}
+	for _, name := range strings.Split(signed, ";") {
+		if name == "host" {
+			continue
+		}
+		for _, v := range r.Header.Values(name) {
+			req.Header.Add(name, v)
+		}
+	}
+	s3.Sign(req, s.Creds, s.Region, date)
+	return req.Header.Get("Authorization") == auth
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>` + code + `</Code></Error>`))
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/app"
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+)
+
+// Размер страницы списка записей кэша: по-умолчанию и наибольший.
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// Admin приложение, кэшем которого можно управлять через административное API.
+type Admin interface {
+	Entries(offset, limit int) ([]app.CacheEntry, int)
+	Entry(key string) (app.Entry, bool)
+	Delete(key string) bool
+	PurgeSource(ref string) int
+	PurgeHost(host string) int
+	PurgePrefix(prefix string) int
+	Clear()
+}
+
+type adminAPI struct {
+	admin  Admin
+	logger Logger
+}
+
+// entryJSON запись кэша в ответах административного API.
+type entryJSON struct {
+	Key          string     `json:"key"`
+	Path         string     `json:"path"`
+	Size         int64      `json:"size"`
+	ContentType  string     `json:"contentType"`
+	ETag         string     `json:"etag,omitempty"`
+	LastModified string     `json:"lastModified,omitempty"`
+	Expires      *time.Time `json:"expires,omitempty"`
+}
+
+func newEntryJSON(key string, entry app.Entry) entryJSON {
+	e := entryJSON{
+		Key:          key,
+		Path:         entry.Path(),
+		Size:         entry.Size,
+		ContentType:  entry.ContentType,
+		ETag:         entry.ETag,
+		LastModified: entry.LastModified,
+	}
+	if !entry.Expires.IsZero() {
+		e.Expires = &entry.Expires
+	}
+	return e
+}
+
+// NewAdminRouter маршруты административного API кэша, доступные только с токеном cfg.Token.
+func NewAdminRouter(cfg config.AdminCfg, admin Admin, logger Logger) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mw := func(next http.HandlerFunc, allowed ...string) http.HandlerFunc {
+		return HTTPLogger(RequireToken(CheckHTTPMethod(next, allowed...), cfg.Token))
+	}
+
+	a := &adminAPI{admin: admin, logger: logger}
+
+	mux.HandleFunc("/admin/cache", mw(a.cache, http.MethodGet, http.MethodDelete))
+	mux.HandleFunc("/admin/cache/entry", mw(a.entry, http.MethodGet, http.MethodDelete))
+	mux.HandleFunc("/admin/cache/purge", mw(a.purge, http.MethodPost))
+
+	return mux
+}
+
+// cache отдает страницу записей кэша (GET /admin/cache?offset=0&limit=100)
+// или очищает весь кэш (DELETE /admin/cache).
+func (a *adminAPI) cache(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		a.admin.Clear()
+		a.logger.Info("cache cleared by admin request")
+		NoContent(w, r)
+		return
+	}
+
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		ErrorJSON(w, r, http.StatusBadRequest, err, "offset should be a non-negative number")
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultPageLimit)
+	if err != nil || limit == 0 {
+		ErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("wrong limit: %q", r.URL.Query().Get("limit")),
+			fmt.Sprintf("limit should be from 1 to %d", maxPageLimit))
+		return
+	}
+	limit = min(limit, maxPageLimit)
+
+	page, total := a.admin.Entries(offset, limit)
+	entries := make([]entryJSON, 0, len(page))
+	for _, e := range page {
+		entries = append(entries, newEntryJSON(e.Key, e.Entry))
+	}
+	responseJSON(w, r, http.StatusOK, JSONMap{"total": total, "offset": offset, "limit": limit, "entries": entries})
+}
+
+// entry отдает (GET) или удаляет (DELETE) запись кэша по точному ключу:
+// /admin/cache/entry?key=/fill/300/200/example.com/img.jpg.
+func (a *adminAPI) entry(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		ErrorJSON(w, r, http.StatusBadRequest, errors.New("empty key"), "key query parameter is required")
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if !a.admin.Delete(key) {
+			ErrorJSON(w, r, http.StatusNotFound, ErrNotFound, key)
+			return
+		}
+		a.logger.Info(fmt.Sprintf("cache entry deleted by admin request: %s", key))
+		NoContent(w, r)
+		return
+	}
+
+	entry, ok := a.admin.Entry(key)
+	if !ok {
+		ErrorJSON(w, r, http.StatusNotFound, ErrNotFound, key)
+		return
+	}
+	responseJSON(w, r, http.StatusOK, newEntryJSON(key, entry))
+}
+
+// purge удаляет превью по исходнику (POST /admin/cache/purge?source=example.com/img.jpg),
+// по хосту (?host=example.com) или по префиксу ссылки на исходник (?prefix=example.com/photos/).
+func (a *adminAPI) purge(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var purged int
+	switch {
+	case query.Get("source") != "":
+		purged = a.admin.PurgeSource(query.Get("source"))
+	case query.Get("host") != "":
+		purged = a.admin.PurgeHost(query.Get("host"))
+	case query.Get("prefix") != "":
+		purged = a.admin.PurgePrefix(query.Get("prefix"))
+	default:
+		ErrorJSON(w, r, http.StatusBadRequest, errors.New("empty purge filter"),
+			"one of source, host or prefix query parameters is required")
+		return
+	}
+	a.logger.Info(fmt.Sprintf("%d previews purged by admin request: %s", purged, r.URL.RawQuery))
+	responseJSON(w, r, http.StatusOK, JSONMap{"purged": purged})
+}
+
+// queryInt читает неотрицательное число из параметра запроса name, def - если параметра нет.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	str := r.URL.Query().Get(name)
+	if str == "" {
+		return def, nil
+	}
+	val, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("wrong %s: %w", name, err)
+	}
+	if val < 0 {
+		return 0, fmt.Errorf("wrong %s: %d", name, val)
+	}
+	return val, nil
+}
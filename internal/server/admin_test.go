@@ -0,0 +1,141 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/app"
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+// mapAdmin кэш в map, очистка по исходнику удаляет ключи, которые им заканчиваются.
+type mapAdmin map[string]app.Entry
+
+func (m mapAdmin) Entries(offset, limit int) ([]app.CacheEntry, int) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	offset = min(offset, len(keys))
+	keys = keys[offset:min(offset+limit, len(keys))]
+	entries := make([]app.CacheEntry, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, app.CacheEntry{Key: key, Entry: m[key]})
+	}
+	return entries, len(m)
+}
+
+func (m mapAdmin) Entry(key string) (app.Entry, bool) {
+	entry, ok := m[key]
+	return entry, ok
+}
+
+func (m mapAdmin) Delete(key string) bool {
+	_, ok := m[key]
+	delete(m, key)
+	return ok
+}
+
+func (m mapAdmin) PurgeSource(ref string) int {
+	purged := 0
+	for key := range m {
+		if strings.HasSuffix(key, "/"+ref) {
+			delete(m, key)
+			purged++
+		}
+	}
+	return purged
+}
+
+func (m mapAdmin) PurgeHost(string) int   { return 0 }
+func (m mapAdmin) PurgePrefix(string) int { return 0 }
+
+func (m mapAdmin) Clear() {
+	for key := range m {
+		delete(m, key)
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	admin := mapAdmin{
+		"/fill/10/10/example.com/a.jpg": {Size: 10, ContentType: "image/jpeg", ETag: `"a"`},
+		"/fill/20/10/example.com/a.jpg": {Size: 20, ContentType: "image/jpeg"},
+		"/fill/10/10/example.com/b.jpg": {Size: 30, ContentType: "image/jpeg"},
+	}
+	router := NewAdminRouter(config.AdminCfg{Token: "secret"}, admin, nopLogger{})
+	do := func(method, target, token string) (*http.Response, map[string]interface{}) {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		body := map[string]interface{}{}
+		if rec.Body.Len() > 0 {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		}
+		return rec.Result(), body
+	}
+
+	resp, _ := do(http.MethodGet, "/admin/cache", "")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Equal(t, "Bearer", resp.Header.Get("WWW-Authenticate"))
+	resp, _ = do(http.MethodGet, "/admin/cache", "wrong")
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, body := do(http.MethodGet, "/admin/cache?offset=1&limit=1", "secret")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, float64(3), body["total"])
+	entries := body["entries"].([]interface{})
+	require.Len(t, entries, 1)
+	require.Equal(t, "/fill/10/10/example.com/b.jpg", entries[0].(map[string]interface{})["key"])
+
+	resp, _ = do(http.MethodGet, "/admin/cache?limit=-1", "secret")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, body = do(http.MethodGet, "/admin/cache/entry?key=/fill/10/10/example.com/a.jpg", "secret")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, float64(10), body["size"])
+	require.Equal(t, `"a"`, body["etag"])
+	require.NotContains(t, body, "expires")
+
+	resp, _ = do(http.MethodGet, "/admin/cache/entry?key=/fill/1/1/missing.jpg", "secret")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = do(http.MethodDelete, "/admin/cache/entry?key=/fill/10/10/example.com/b.jpg", "secret")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Len(t, admin, 2)
+
+	resp, _ = do(http.MethodPost, "/admin/cache/purge", "secret")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, body = do(http.MethodPost, "/admin/cache/purge?source=example.com/a.jpg", "secret")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, float64(2), body["purged"])
+	require.Empty(t, admin)
+
+	admin["/fill/10/10/example.com/c.jpg"] = app.Entry{}
+	resp, _ = do(http.MethodPut, "/admin/cache", "secret")
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp, _ = do(http.MethodDelete, "/admin/cache", "secret")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	require.Empty(t, admin)
+}
+
+func TestAdminServerRequiresToken(t *testing.T) {
+	require.Nil(t, newAdminServer(config.AdminCfg{}, mapAppAdmin{}, nopLogger{}))
+	require.Nil(t, newAdminServer(config.AdminCfg{Addr: ":0"}, mapAppAdmin{}, nopLogger{}))
+	require.Nil(t, newAdminServer(config.AdminCfg{Addr: ":0", Token: "secret"}, echoApp{}, nopLogger{}))
+	require.NotNil(t, newAdminServer(config.AdminCfg{Addr: ":0", Token: "secret"}, mapAppAdmin{}, nopLogger{}))
+}
+
+// mapAppAdmin приложение с административным API.
+type mapAppAdmin struct {
+	getOnlyApp
+	mapAdmin
+}
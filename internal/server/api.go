@@ -1,23 +1,35 @@
 package server
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
-	"net/url"
-	"os"
 	"strings"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/app"
+	"github.com/Ser9unin/ImagePreviewer/internal/peer"
 )
 
+// multipartOverhead запас на заголовки частей и прочие поля формы multipart/form-data
+// сверх лимита на само изображение.
+const multipartOverhead = 64 << 10
+
+// uploadField поле формы multipart/form-data с изображением.
+const uploadField = "image"
+
 type api struct {
-	app         App
-	logger      Logger
-	storagePath string
+	app            App
+	logger         Logger
+	maxUploadBytes int64
 }
 
-func newAPI(app App, logger Logger) api {
-	return api{
-		app:         app,
-		logger:      logger,
-		storagePath: "./internal/storage/",
+func newAPI(app App, maxUploadBytes int64, logger Logger) *api {
+	return &api{
+		app:            app,
+		logger:         logger,
+		maxUploadBytes: maxUploadBytes,
 	}
 }
 
@@ -27,56 +39,123 @@ func (a *api) greetings(w http.ResponseWriter, _ *http.Request) {
 	w.Write([]byte("<h1>This is my previewer!</h1>"))
 }
 
-func (a *api) fill(w http.ResponseWriter, r *http.Request) {
-	urlString := r.URL.String()
-	paramsStr, err := url.Parse(urlString)
-	if err != nil {
-		a.logger.Error(err.Error())
-		ErrorJSON(w, r, http.StatusBadRequest, err, "not correct path")
+// metrics отдает состояние приложения: circuit breaker источников и т.п.
+func (a *api) metrics(w http.ResponseWriter, r *http.Request) {
+	metrics := map[string]interface{}{}
+	if reporter, ok := a.app.(Reporter); ok {
+		metrics = reporter.Metrics()
 	}
-	cachePath, ok := a.app.Get(paramsStr.Path)
-	if ok {
-		filePath := a.storagePath + cachePath.(string)
-		fileFromDisc, err := os.ReadFile(filePath)
-		if err != nil {
-			a.logger.Error(err.Error())
-			a.logger.Info("image not found on disk")
-			a.externalUpload(w, r, paramsStr.Path)
-		} else {
-			a.logger.Info("image get from cache")
-			w.Header().Set("Get_from_cache", "1")
-			responseImage(w, r, http.StatusOK, fileFromDisc)
-		}
-	} else {
-		a.externalUpload(w, r, paramsStr.Path)
+	responseJSON(w, r, http.StatusOK, metrics)
+}
+
+// fill отдает превью изображения по ссылке (GET /fill/{w}/{h}/{source})
+// или присланного в теле запроса (POST /fill/{w}/{h}).
+func (a *api) fill(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		a.upload(w, r)
+		return
 	}
+	preview, err := a.app.Preview(r.Context(), r.URL.Path, r.Header)
+	a.respondPreview(w, r, preview, err)
 }
 
-func (a *api) externalUpload(w http.ResponseWriter, r *http.Request, paramsStr string) {
-	paramsURL := parseTargetURL(paramsStr)
-	targetReq, httpStatus, err := a.app.ProxyHeader(paramsURL, r.Header)
-	if err != nil {
-		a.logger.Error(err.Error())
-		ErrorJSON(w, r, httpStatus, err, "fail proxy request header")
+// peerFill отдает другому экземпляру сервиса превью, принадлежащее этому экземпляру
+// (GET /peer/fill/{w}/{h}/{source}).
+func (a *api) peerFill(w http.ResponseWriter, r *http.Request) {
+	local := a.app.(LocalPreviewer)
+	preview, err := local.LocalPreview(r.Context(), strings.TrimPrefix(r.URL.Path, peer.PathPrefix), r.Header)
+	a.respondPreview(w, r, preview, err)
+}
+
+// upload делает превью из изображения в теле запроса: как есть
+// или в поле image формы multipart/form-data.
+func (a *api) upload(w http.ResponseWriter, r *http.Request) {
+	uploader, ok := a.app.(Uploader)
+	if !ok {
+		ErrorJSON(w, r, http.StatusMethodNotAllowed, fmt.Errorf("bad method: %s", r.Method), "upload is not supported")
 		return
 	}
-	externalData, httpStatus, err := a.app.FetchExternalData(targetReq)
+	data, status, err := a.readUpload(w, r)
 	if err != nil {
 		a.logger.Error(err.Error())
-		ErrorJSON(w, r, httpStatus, err, "fail fetch data request")
+		ErrorJSON(w, r, status, err, "fail read upload")
 		return
 	}
-	response, err := a.app.Fill(externalData, paramsStr)
+	preview, err := uploader.PreviewUpload(r.Context(), r.URL.Path, data)
+	a.respondPreview(w, r, preview, err)
+}
+
+func (a *api) respondPreview(w http.ResponseWriter, r *http.Request, preview *app.Preview, err error) {
 	if err != nil {
+		if r.Context().Err() != nil {
+			a.logger.Warn("client gone while waiting for preview")
+			return
+		}
 		a.logger.Error(err.Error())
-		ErrorJSON(w, r, httpStatus, err, "fail fetch data")
+		status, details := http.StatusInternalServerError, "fail fetch data"
+		var pErr *app.PreviewError
+		if errors.As(err, &pErr) {
+			status, details = pErr.Status, pErr.Details
+		}
+		ErrorJSON(w, r, status, err, details)
 		return
 	}
-	w.Header().Set("get_from_remote_server", "1")
-	responseImage(w, r, httpStatus, response)
+
+	if preview.FromCache {
+		w.Header().Set("Get_from_cache", "1")
+	} else {
+		w.Header().Set("get_from_remote_server", "1")
+	}
+	w.Header().Set("Freshness", preview.Freshness)
+	responseImage(w, r, http.StatusOK, preview.Data)
 }
 
-func parseTargetURL(paramsStr string) string {
-	splitParams := strings.Split(paramsStr, "/")
-	return strings.Join(splitParams[4:], "/")
+// readUpload читает изображение из тела запроса не больше maxUploadBytes.
+func (a *api) readUpload(w http.ResponseWriter, r *http.Request) ([]byte, int, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		r.Body = http.MaxBytesReader(w, r.Body, a.maxUploadBytes)
+		return readLimited(r.Body, a.maxUploadBytes)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.maxUploadBytes+multipartOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("malformed multipart form: %w", err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, http.StatusBadRequest, fmt.Errorf("no %q field in form", uploadField)
+		}
+		if err != nil {
+			return nil, uploadErrorStatus(err), fmt.Errorf("malformed multipart form: %w", err)
+		}
+		if part.FormName() == uploadField {
+			return readLimited(part, a.maxUploadBytes)
+		}
+	}
+}
+
+// readLimited читает изображение до конца или до превышения лимита (статус 413).
+func readLimited(body io.Reader, limit int64) ([]byte, int, error) {
+	data, err := io.ReadAll(io.LimitReader(body, limit+1))
+	if err != nil {
+		return nil, uploadErrorStatus(err), fmt.Errorf("error reading upload: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("data exceed limit")
+	}
+	if len(data) == 0 {
+		return nil, http.StatusBadRequest, fmt.Errorf("empty image")
+	}
+	return data, http.StatusOK, nil
+}
+
+func uploadErrorStatus(err error) int {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
 }
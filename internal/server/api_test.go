@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/app"
+	"github.com/Ser9unin/ImagePreviewer/internal/cache"
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/Ser9unin/ImagePreviewer/internal/source"
+	"github.com/stretchr/testify/require"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string)  {}
+func (nopLogger) Error(string) {}
+func (nopLogger) Debug(string) {}
+func (nopLogger) Warn(string)  {}
+
+// echoApp отдает в качестве превью путь запроса или присланные байты.
+type echoApp struct{}
+
+func (echoApp) Preview(_ context.Context, path string, _ http.Header) (*app.Preview, error) {
+	return &app.Preview{Data: []byte(path), Freshness: app.Fresh}, nil
+}
+
+func (echoApp) PreviewUpload(_ context.Context, _ string, data []byte) (*app.Preview, error) {
+	return &app.Preview{Data: data, Freshness: app.Fresh}, nil
+}
+
+// getOnlyApp не умеет делать превью из присланных изображений.
+type getOnlyApp struct{}
+
+func (getOnlyApp) Preview(context.Context, string, http.Header) (*app.Preview, error) {
+	return &app.Preview{}, nil
+}
+
+func TestFillMethods(t *testing.T) {
+	router := NewRouter(config.SrvCfg{MaxUploadBytes: 16}, echoApp{}, nopLogger{})
+	do := func(req *http.Request) (*http.Response, string) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		body, err := io.ReadAll(rec.Result().Body)
+		require.NoError(t, err)
+		return rec.Result(), string(body)
+	}
+
+	resp, body := do(httptest.NewRequest(http.MethodGet, "/fill/10/10/example.com/beaver.jpg", nil))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "image/jpeg", resp.Header.Get("Content-Type"))
+	require.Equal(t, "/fill/10/10/example.com/beaver.jpg", body)
+
+	resp, _ = do(httptest.NewRequest(http.MethodDelete, "/fill/10/10/example.com/beaver.jpg", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	require.Equal(t, "GET, POST", resp.Header.Get("Allow"))
+
+	t.Run("raw body", func(t *testing.T) {
+		resp, body := do(httptest.NewRequest(http.MethodPost, "/fill/10/10", bytes.NewReader([]byte("jpeg"))))
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "jpeg", body)
+
+		resp, _ = do(httptest.NewRequest(http.MethodPost, "/fill/10/10", bytes.NewReader(make([]byte, 17))))
+		require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+		resp, _ = do(httptest.NewRequest(http.MethodPost, "/fill/10/10", http.NoBody))
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		form := func(field string, data []byte) *http.Request {
+			buf := &bytes.Buffer{}
+			mw := multipart.NewWriter(buf)
+			require.NoError(t, mw.WriteField("comment", "beaver"))
+			part, err := mw.CreateFormFile(field, "beaver.jpg")
+			require.NoError(t, err)
+			part.Write(data)
+			require.NoError(t, mw.Close())
+			req := httptest.NewRequest(http.MethodPost, "/fill/10/10", buf)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			return req
+		}
+
+		resp, body := do(form("image", []byte("jpeg")))
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "jpeg", body)
+
+		resp, _ = do(form("image", make([]byte, 17)))
+		require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+		resp, _ = do(form("file", []byte("jpeg")))
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestFillWithoutUploads(t *testing.T) {
+	router := NewRouter(config.SrvCfg{MaxUploadBytes: 16}, getOnlyApp{}, nopLogger{})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/fill/10/10", bytes.NewReader([]byte("jpeg"))))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	require.Equal(t, "GET", rec.Header().Get("Allow"))
+}
+
+func TestFillDataURI(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+	cfg := config.Config{
+		Upstream: config.UpstreamCfg{MaxSourceBytes: 10 << 20},
+		Storage:  config.StorageCfg{Path: t.TempDir()},
+	}
+	previewer := app.New(cfg, cache.New[string, app.Entry](config.CacheCfg{Capacity: 10}), source.NewDefault(cfg, nopLogger{}), nopLogger{})
+	router := NewRouter(cfg.Server, previewer, nopLogger{})
+
+	// в стандартном base64 JPEG встречаются "//", http.ServeMux ответил бы на такой путь редиректом
+	encoded := base64.StdEncoding.EncodeToString(img)
+	require.Contains(t, encoded, "//")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fill/50/40/data:image/jpeg;base64,"+encoded, nil))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "image/jpeg", rec.Header().Get("Content-Type"))
+
+	// остальные пути по-прежнему нормализуются
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fill/50/40//example.com/img.jpg", nil))
+	require.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	cfg := config.Config{Upstream: config.UpstreamCfg{Timeout: 8 * time.Second}}
+	require.Equal(t, 10*time.Second, writeTimeout(cfg))
+
+	// ответ может ждать владельца превью, а затем загрузку исходника здесь
+	cfg.Peer = config.PeerCfg{Peers: []string{"http://a:8000"}, Timeout: 10 * time.Second}
+	require.Equal(t, 20*time.Second, writeTimeout(cfg))
+}
@@ -1,8 +1,12 @@
 package server
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -61,12 +65,33 @@ func HTTPLogger(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func CheckHTTPMethod(next http.HandlerFunc) http.HandlerFunc {
+// CheckHTTPMethod пропускает к обработчику только запросы с разрешенными методами,
+// по-умолчанию - только GET.
+func CheckHTTPMethod(next http.HandlerFunc, allowed ...string) http.HandlerFunc {
+	if len(allowed) == 0 {
+		allowed = []string{http.MethodGet}
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(allowed, r.Method) {
+			methods := strings.Join(allowed, ", ")
+			w.Header().Set("Allow", methods)
+			ErrorJSON(w, r, http.StatusMethodNotAllowed, fmt.Errorf("bad method: %s", r.Method), "method should be "+methods)
+			return
+		}
 		next(w, r)
-		if r.Method != http.MethodGet {
-			ErrorJSON(w, r, http.StatusBadRequest, fmt.Errorf("bad method: %s", r.Method), "method should be get")
+	}
+}
+
+// RequireToken пропускает к обработчику только запросы с заголовком Authorization: Bearer {token}.
+func RequireToken(next http.HandlerFunc, token string) http.HandlerFunc {
+	expected := []byte("Bearer " + token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(got, expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			ErrorJSON(w, r, http.StatusUnauthorized, errors.New("unauthorized"), "valid bearer token is required")
 			return
 		}
+		next(w, r)
 	}
 }
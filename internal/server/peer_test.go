@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/app"
+	"github.com/Ser9unin/ImagePreviewer/internal/cache"
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/Ser9unin/ImagePreviewer/internal/peer"
+	"github.com/Ser9unin/ImagePreviewer/internal/source"
+	"github.com/stretchr/testify/require"
+)
+
+// instance экземпляр сервиса на localhost.
+type instance struct {
+	srv   *httptest.Server
+	app   *app.App
+	cache cache.Cache[string, app.Entry]
+}
+
+func TestPeers(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+	var upstreamCalls, downCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down.jpg" {
+			downCalls.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		upstreamCalls.Add(1)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(img)
+	}))
+	defer upstream.Close()
+
+	// адреса известны до запуска серверов, поэтому кольцо строится сразу
+	instances := make([]*instance, 3)
+	addrs := make([]string, len(instances))
+	for i := range instances {
+		instances[i] = &instance{srv: httptest.NewUnstartedServer(nil)}
+		addrs[i] = "http://" + instances[i].srv.Listener.Addr().String()
+	}
+	for i, inst := range instances {
+		cfg := config.Config{
+			Server:   config.SrvCfg{MaxUploadBytes: 1 << 20},
+			Upstream: config.UpstreamCfg{Timeout: 5 * time.Second, RetryAttempts: 1, DefaultTTL: time.Hour},
+			Storage:  config.StorageCfg{Path: t.TempDir()},
+			Peer:     config.PeerCfg{Self: addrs[i], Peers: addrs, Replicas: 50, Timeout: 5 * time.Second, Token: "peer-token"},
+		}
+		inst.cache = cache.New[string, app.Entry](config.CacheCfg{Capacity: 10})
+		inst.app = app.New(cfg, inst.cache, source.NewDefault(cfg, nopLogger{}), nopLogger{})
+		pool, err := peer.NewPool(cfg.Peer)
+		require.NoError(t, err)
+		inst.app.SetPeers(pool)
+		inst.srv.Config.Handler = WithPeers(NewRouter(cfg.Server, inst.app, nopLogger{}), cfg.Peer, inst.app, nopLogger{})
+		inst.srv.Start()
+		defer inst.srv.Close()
+	}
+
+	ring := peer.NewRing(50, addrs...)
+	key := "/fill/50/40/" + strings.TrimPrefix(upstream.URL, "http://") + "/beaver.jpg"
+	owner := 0
+	for i, addr := range addrs {
+		if ring.Owner(key) == addr {
+			owner = i
+		}
+	}
+
+	// любой экземпляр отдает превью, исходник скачивает и хранит превью только владелец
+	var first []byte
+	for _, inst := range instances {
+		resp, err := http.Get(inst.srv.URL + key)
+		require.NoError(t, err)
+		body := readBody(t, resp)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		if first == nil {
+			first = body
+		}
+		require.Equal(t, first, body)
+	}
+	require.Equal(t, int32(1), upstreamCalls.Load())
+	for i, inst := range instances {
+		_, ok := inst.cache.Peek(key)
+		require.Equal(t, i == owner, ok, addrs[i])
+	}
+
+	// без общего токена внутренний адрес владельца не отвечает
+	resp, err := http.Get(instances[owner].srv.URL + peer.PathPrefix + key)
+	require.NoError(t, err)
+	readBody(t, resp)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// ошибка источника у владельца не приводит к повторной загрузке на других экземплярах
+	for _, inst := range instances {
+		resp, err := http.Get(inst.srv.URL + "/fill/50/40/local/photos/missing.jpg")
+		require.NoError(t, err)
+		readBody(t, resp)
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	}
+
+	// сбой источника у владельца не приводит к загрузке на других экземплярах
+	downKey := "/fill/50/40/" + strings.TrimPrefix(upstream.URL, "http://") + "/down.jpg"
+	for _, inst := range instances {
+		resp, err := http.Get(inst.srv.URL + downKey)
+		require.NoError(t, err)
+		readBody(t, resp)
+		require.GreaterOrEqual(t, resp.StatusCode, http.StatusInternalServerError)
+	}
+	require.Equal(t, int32(len(instances)), downCalls.Load())
+
+	// превью присланного изображения есть только у принявшего его экземпляра и отдается им самим
+	sum := sha256.Sum256(img)
+	uploadKey := "/fill/50/40/upload/" + hex.EncodeToString(sum[:]) + ".jpg"
+	receiver := instances[0]
+	if ring.Owner(uploadKey) == addrs[0] {
+		receiver = instances[1]
+	}
+	resp, err = http.Post(receiver.srv.URL+"/fill/50/40", "image/jpeg", bytes.NewReader(img))
+	require.NoError(t, err)
+	readBody(t, resp)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = http.Get(receiver.srv.URL + uploadKey)
+	require.NoError(t, err)
+	readBody(t, resp)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// если владелец недоступен, превью делается на месте
+	instances[owner].srv.Close()
+	other := instances[(owner+1)%len(instances)]
+	resp, err = http.Get(other.srv.URL + key)
+	require.NoError(t, err)
+	require.Equal(t, first, readBody(t, resp))
+	require.Equal(t, int32(2), upstreamCalls.Load())
+	_, ok := other.cache.Peek(key)
+	require.True(t, ok)
+}
+
+func readBody(t *testing.T, resp *http.Response) []byte {
+	t.Helper()
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return body
+}
@@ -10,8 +10,8 @@ import (
 
 // responseImage отправляет клиенту изображение в []byte.
 func responseImage(w http.ResponseWriter, _ *http.Request, status int, data []byte) {
-	w.WriteHeader(status)
 	w.Header().Set("Content-Type", "image/jpeg")
+	w.WriteHeader(status)
 	w.Write(data)
 }
 
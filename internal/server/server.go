@@ -2,19 +2,27 @@ package server
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/Ser9unin/ImagePreviewer/internal/app"
 	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/Ser9unin/ImagePreviewer/internal/peer"
+	"github.com/Ser9unin/ImagePreviewer/internal/source"
 )
 
 type Server struct {
-	srv    *http.Server
-	router *http.ServeMux
-	app    App
-	logger Logger
+	srv     *http.Server
+	router  http.Handler
+	app     App
+	logger  Logger
+	storage config.StorageCfg
+	// admin административное API кэша на отдельном адресе, nil - выключено.
+	admin *http.Server
 }
 
 type Logger interface {
@@ -24,53 +32,182 @@ type Logger interface {
 	Warn(msg string)
 }
 
+// App делает превью по пути запроса /fill/{width}/{height}/{source}.
+// Ошибки с http-статусом и пояснением для клиента возвращаются как *app.PreviewError.
 type App interface {
-	Set(key string, value interface{}) bool
-	Get(key string) (interface{}, bool)
-	Clear()
-	Fill(byteImg []byte, paramsStr string) ([]byte, error)
-	ProxyHeader(url string, headers http.Header) (*http.Request, int, error)
-	FetchExternalData(targetReq *http.Request) ([]byte, int, error)
+	Preview(ctx context.Context, path string, header http.Header) (*app.Preview, error)
+}
+
+// Uploader приложение, которое делает превью из изображения, присланного в POST /fill/{width}/{height}.
+type Uploader interface {
+	PreviewUpload(ctx context.Context, path string, data []byte) (*app.Preview, error)
+}
+
+// LocalPreviewer приложение, которое отдает превью, принадлежащие ему, другим экземплярам сервиса
+// по адресу /peer/fill/{width}/{height}/{source}, не пересылая запрос дальше.
+type LocalPreviewer interface {
+	LocalPreview(ctx context.Context, path string, header http.Header) (*app.Preview, error)
+}
+
+// Reporter приложение, которое сообщает свое состояние для /metrics.
+type Reporter interface {
+	Metrics() map[string]interface{}
+}
+
+// defaultWriteTimeout срок записи ответа сервера превью, если ожидание источника и владельца
+// превью в него укладывается.
+const defaultWriteTimeout = 10 * time.Second
+
+// renderMargin запас срока записи ответа на то, чтобы сделать превью и отправить его.
+const renderMargin = 2 * time.Second
+
+// writeTimeout срок записи ответа сервера превью. Запрос может прождать владельца превью
+// Peer.Timeout, а затем скачать исходник здесь за Upstream.Timeout: срок записи покрывает оба,
+// иначе вместо превью или 504 клиент получит обрыв соединения.
+func writeTimeout(cfg config.Config) time.Duration {
+	wait := cfg.Upstream.Timeout
+	if len(cfg.Peer.Peers) > 0 {
+		wait += cfg.Peer.Timeout
+	}
+	return max(defaultWriteTimeout, wait+renderMargin)
 }
 
 func NewServer(cfg config.Config, app App, logger Logger) *Server {
-	router := NewRouter(app, logger)
+	router := WithPeers(NewRouter(cfg.Server, app, logger), cfg.Peer, app, logger)
 
 	srv := &http.Server{
 		Addr:              cfg.Server.Host + cfg.Server.Port,
 		Handler:           router,
-		ReadHeaderTimeout: 15 * time.Second, // Настраиваем тайм-аут ожидания заголовков
-		ReadTimeout:       15 * time.Second, // Настраиваем общий тайм-аут запроса
-		WriteTimeout:      10 * time.Second, // Настраиваем тайм-аут записи ответа
-		IdleTimeout:       30 * time.Second, // Настраиваем тайм-аут простоя соединения
+		ReadHeaderTimeout: 15 * time.Second,  // Настраиваем тайм-аут ожидания заголовков
+		ReadTimeout:       15 * time.Second,  // Настраиваем общий тайм-аут запроса
+		WriteTimeout:      writeTimeout(cfg), // Настраиваем тайм-аут записи ответа
+		IdleTimeout:       30 * time.Second,  // Настраиваем тайм-аут простоя соединения
 	}
 
-	return &Server{srv, router, app, logger}
+	return &Server{srv, router, app, logger, cfg.Storage, newAdminServer(cfg.Admin, app, logger)}
+}
+
+// newAdminServer создает сервер административного API, если задан его адрес,
+// токен доступа и приложение поддерживает управление кэшем.
+func newAdminServer(cfg config.AdminCfg, app App, logger Logger) *http.Server {
+	if cfg.Addr == "" {
+		return nil
+	}
+	admin, ok := app.(Admin)
+	if !ok {
+		logger.Warn("admin API disabled: application does not support cache administration")
+		return nil
+	}
+	if cfg.Token == "" {
+		logger.Error("admin API disabled: ADMIN_TOKEN is not set")
+		return nil
+	}
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           NewAdminRouter(cfg, admin, logger),
+		ReadHeaderTimeout: 15 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      30 * time.Second, // очистка большого кэша может занять время
+		IdleTimeout:       30 * time.Second,
+	}
 }
 
-func NewRouter(app App, logger Logger) *http.ServeMux {
+func NewRouter(cfg config.SrvCfg, app App, logger Logger) http.Handler {
 	mux := http.NewServeMux()
 
-	mw := func(next http.HandlerFunc) http.HandlerFunc {
-		return HTTPLogger(CheckHTTPMethod(next))
+	mw := func(next http.HandlerFunc, allowed ...string) http.HandlerFunc {
+		return HTTPLogger(CheckHTTPMethod(next, allowed...))
 	}
 
-	a := newAPI(app, logger)
+	a := newAPI(app, cfg.MaxUploadBytes, logger)
+
+	fillMethods := []string{http.MethodGet}
+	if _, ok := app.(Uploader); ok {
+		fillMethods = append(fillMethods, http.MethodPost)
+	}
 
 	mux.HandleFunc("/", mw(a.greetings))
-	mux.HandleFunc("/fill/", mw(a.fill))
+	fill := mw(a.fill, fillMethods...)
+	mux.HandleFunc("/fill/", fill)
+	mux.HandleFunc("/metrics", mw(a.metrics))
+
+	return &router{mux: mux, fill: fill}
+}
+
+// WithPeers добавляет к маршрутам public адрес /peer/fill/, по которому экземпляр отдает
+// свои превью другим экземплярам. Адрес доступен только с общим токеном cfg.Token,
+// иначе любой клиент мог бы обойти выбор владельца и заставить экземпляр
+// скачивать и хранить чужие превью. Без токена адрес не включается.
+func WithPeers(public http.Handler, cfg config.PeerCfg, app App, logger Logger) http.Handler {
+	if len(cfg.Peers) == 0 {
+		return public
+	}
+	if _, ok := app.(LocalPreviewer); !ok {
+		return public
+	}
+	if cfg.Token == "" {
+		logger.Error("peer endpoint disabled: PEER_TOKEN is not set")
+		return public
+	}
+	a := newAPI(app, 0, logger)
+	peerFill := HTTPLogger(RequireToken(CheckHTTPMethod(a.peerFill, http.MethodGet), cfg.Token))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, peer.PathPrefix+"/fill/") {
+			peerFill(w, r)
+			return
+		}
+		public.ServeHTTP(w, r)
+	})
+}
 
-	return mux
+// router передает запросы превью из data: URI прямо обработчику /fill/, остальные - в mux.
+// http.ServeMux отвечает редиректом на путь, в котором "//" схлопнуты в "/",
+// а в стандартном base64 "//" встречаются постоянно, и после редиректа данные не декодируются.
+type router struct {
+	mux  *http.ServeMux
+	fill http.HandlerFunc
 }
 
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isDataPath(r.URL.Path) {
+		rt.fill(w, r)
+		return
+	}
+	rt.mux.ServeHTTP(w, r)
+}
+
+// isDataPath сообщает, что путь имеет вид /fill/{width}/{height}/data:....
+func isDataPath(path string) bool {
+	rest, ok := strings.CutPrefix(path, "/fill/")
+	if !ok {
+		return false
+	}
+	parts := strings.SplitN(rest, "/", 3)
+	return len(parts) == 3 && strings.HasPrefix(parts[2], source.DataPrefix)
+}
+
+// Run запускает сервер превью и административное API, если оно включено.
+// Возвращается, как только остановится любой из них.
 func (s *Server) Run() error {
-	return s.srv.ListenAndServe()
+	if s.admin == nil {
+		return s.srv.ListenAndServe()
+	}
+	errs := make(chan error, 2)
+	go func() { errs <- s.admin.ListenAndServe() }()
+	go func() { errs <- s.srv.ListenAndServe() }()
+	return <-errs
 }
 
 func (s *Server) Stop(ctx context.Context) error {
-	err := os.RemoveAll("./internal/storage/")
-	if err != nil {
-		log.Println("Ошибка при удалении папки:", err)
+	err := s.srv.Shutdown(ctx)
+	if s.admin != nil {
+		err = errors.Join(err, s.admin.Shutdown(ctx))
+	}
+	// превью сохраняются между запусками, если не включено удаление при остановке
+	if s.storage.WipeOnShutdown {
+		if err := os.RemoveAll(s.storage.Path); err != nil {
+			log.Println("Ошибка при удалении папки:", err)
+		}
 	}
-	return s.srv.Shutdown(ctx)
+	return err
 }
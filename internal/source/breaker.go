@@ -0,0 +1,193 @@
+package source
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+)
+
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case stateOpen:
+		return "open"
+	case stateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breakerResult итог запроса к источнику с точки зрения circuit breaker.
+type breakerResult int
+
+const (
+	resultSuccess breakerResult = iota
+	resultFailure
+	// resultIgnored запрос прерван не по вине источника (например, клиент закрыл соединение).
+	resultIgnored
+)
+
+// breakerIdleTTL через сколько без запросов забывается закрытый breaker хоста.
+const breakerIdleTTL = 10 * time.Minute
+
+type hostBreaker struct {
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probes   int
+	// seen время последнего запроса к хосту.
+	seen time.Time
+}
+
+// BreakerStatus состояние circuit breaker одного хоста для отчета в /metrics.
+type BreakerStatus struct {
+	State    string    `json:"state"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"openedAt,omitempty"`
+}
+
+// breakers circuit breaker источников, который ведется отдельно для каждого хоста.
+// Closed - запросы проходят, Open - запросы отклоняются до истечения Cooldown,
+// Half-open - пропускается HalfOpenProbes пробных запросов, по их результату
+// breaker снова закрывается или открывается.
+// Учет ведется только для хостов, запросы к которым заканчивались ошибкой,
+// закрытые breaker забываются через breakerIdleTTL без запросов.
+type breakers struct {
+	mu    sync.Mutex
+	cfg   config.BreakerCfg
+	hosts map[string]*hostBreaker
+	now   func() time.Time
+}
+
+func newBreakers(cfg config.BreakerCfg) *breakers {
+	if cfg.HalfOpenProbes < 1 {
+		cfg.HalfOpenProbes = 1
+	}
+	return &breakers{
+		cfg:   cfg,
+		hosts: make(map[string]*hostBreaker),
+		now:   time.Now,
+	}
+}
+
+func (b *breakers) enabled() bool {
+	return b.cfg.FailureThreshold > 0
+}
+
+// allow решает, можно ли сейчас отправить запрос к host.
+// Каждый разрешенный запрос должен завершаться вызовом done.
+func (b *breakers) allow(host string) error {
+	if !b.enabled() {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	hb, ok := b.hosts[host]
+	if !ok {
+		return nil
+	}
+	now := b.now()
+	hb.seen = now
+
+	if hb.state == stateOpen {
+		if now.Sub(hb.openedAt) < b.cfg.Cooldown {
+			return errBreakerOpen
+		}
+		hb.state = stateHalfOpen
+		hb.probes = 0
+	}
+	if hb.state == stateHalfOpen {
+		if hb.probes >= b.cfg.HalfOpenProbes {
+			return errBreakerOpen
+		}
+		hb.probes++
+	}
+	return nil
+}
+
+func (b *breakers) done(host string, result breakerResult) {
+	if !b.enabled() {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	hb, ok := b.hosts[host]
+	if !ok {
+		if result != resultFailure {
+			return
+		}
+		b.forgetIdle(now)
+		hb = &hostBreaker{}
+		b.hosts[host] = hb
+	}
+	hb.seen = now
+
+	switch hb.state {
+	case stateClosed:
+		switch result {
+		case resultSuccess:
+			hb.failures = 0
+		case resultFailure:
+			hb.failures++
+			if hb.failures >= b.cfg.FailureThreshold {
+				hb.state = stateOpen
+				hb.openedAt = now
+			}
+		case resultIgnored:
+		}
+	case stateHalfOpen:
+		hb.probes--
+		switch result {
+		case resultSuccess:
+			hb.state = stateClosed
+			hb.failures = 0
+		case resultFailure:
+			hb.failures++
+			hb.state = stateOpen
+			hb.openedAt = now
+		case resultIgnored:
+		}
+	case stateOpen:
+	}
+}
+
+// forgetIdle удаляет закрытые breaker хостов, к которым давно не было запросов.
+func (b *breakers) forgetIdle(now time.Time) {
+	for host, hb := range b.hosts {
+		if hb.state == stateClosed && now.Sub(hb.seen) > breakerIdleTTL {
+			delete(b.hosts, host)
+		}
+	}
+}
+
+func (b *breakers) states() map[string]BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.forgetIdle(b.now())
+
+	states := make(map[string]BreakerStatus, len(b.hosts))
+	for host, hb := range b.hosts {
+		status := BreakerStatus{State: hb.state.String(), Failures: hb.failures}
+		if hb.state != stateClosed {
+			status.OpenedAt = hb.openedAt
+		}
+		states[host] = status
+	}
+	return states
+}
@@ -0,0 +1,73 @@
+package source
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreakers(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newBreakers(config.BreakerCfg{FailureThreshold: 2, Cooldown: time.Minute, HalfOpenProbes: 1})
+	b.now = func() time.Time { return now }
+
+	t.Run("opens after consecutive failures", func(t *testing.T) {
+		require.NoError(t, b.allow("cdn.example"))
+		b.done("cdn.example", resultFailure)
+		require.NoError(t, b.allow("cdn.example"))
+		b.done("cdn.example", resultFailure)
+
+		require.ErrorIs(t, b.allow("cdn.example"), errBreakerOpen)
+		require.Equal(t, "open", b.states()["cdn.example"].State)
+
+		// остальные хосты не затронуты
+		require.NoError(t, b.allow("other.example"))
+		b.done("other.example", resultSuccess)
+	})
+
+	t.Run("half-open lets one probe through", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		require.NoError(t, b.allow("cdn.example"))
+		require.ErrorIs(t, b.allow("cdn.example"), errBreakerOpen)
+		require.Equal(t, "half-open", b.states()["cdn.example"].State)
+
+		b.done("cdn.example", resultFailure)
+		require.ErrorIs(t, b.allow("cdn.example"), errBreakerOpen)
+	})
+
+	t.Run("successful probe closes breaker", func(t *testing.T) {
+		now = now.Add(time.Minute)
+		require.NoError(t, b.allow("cdn.example"))
+		b.done("cdn.example", resultSuccess)
+
+		require.Equal(t, "closed", b.states()["cdn.example"].State)
+		require.NoError(t, b.allow("cdn.example"))
+		b.done("cdn.example", resultSuccess)
+	})
+
+	t.Run("ignored result releases probe", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			require.NoError(t, b.allow("cdn.example"))
+			b.done("cdn.example", resultFailure)
+		}
+		now = now.Add(time.Minute)
+		require.NoError(t, b.allow("cdn.example"))
+		b.done("cdn.example", resultIgnored)
+		require.NoError(t, b.allow("cdn.example"))
+		b.done("cdn.example", resultSuccess)
+	})
+	t.Run("tracks only failing hosts", func(t *testing.T) {
+		require.NoError(t, b.allow("ok.example"))
+		b.done("ok.example", resultSuccess)
+		_, ok := b.states()["ok.example"]
+		require.False(t, ok)
+
+		// закрытый breaker забывается, если к хосту долго не было запросов
+		require.Equal(t, "closed", b.states()["cdn.example"].State)
+		now = now.Add(breakerIdleTTL + time.Second)
+		_, ok = b.states()["cdn.example"]
+		require.False(t, ok)
+	})
+}
@@ -0,0 +1,81 @@
+package source
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DataPrefix префикс исходников, переданных прямо в ссылке (RFC 2397):
+// /fill/300/200/data:image/jpeg;base64,/9j/4AAQ...
+// Base64 может быть и в варианте для URL (- и _ вместо + и /), и без дополнения =.
+const DataPrefix = "data:"
+
+// dataTTL срок свежести data: URI: содержимое определяется ссылкой и никогда не меняется.
+const dataTTL = 100 * 365 * 24 * time.Hour
+
+// Data декодирует исходники из data: URI.
+type Data struct {
+	maxBytes int64
+}
+
+func NewData(maxBytes int64) *Data {
+	return &Data{maxBytes: maxBytes}
+}
+
+// Fetch декодирует ссылку вида image/jpeg;base64,... (без префикса data:).
+func (d *Data) Fetch(_ context.Context, req Request) (*Object, int, error) {
+	meta, payload, ok := strings.Cut(req.Ref, ",")
+	if !ok {
+		return nil, http.StatusBadRequest, fmt.Errorf("malformed data URI")
+	}
+	mediaType, params, _ := strings.Cut(meta, ";")
+	if mediaType != "" && !strings.EqualFold(mediaType, "image/jpeg") {
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("not a JPEG image")
+	}
+	// закодированные данные не короче декодированных, лишнего не декодируем
+	if int64(len(payload)) > d.maxBytes*2 {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("data exceed limit")
+	}
+
+	var data []byte
+	var err error
+	if strings.HasSuffix(strings.ToLower(params), "base64") {
+		data, err = decodeBase64(payload)
+	} else {
+		var unescaped string
+		unescaped, err = url.PathUnescape(payload)
+		data = []byte(unescaped)
+	}
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("malformed data URI: %w", err)
+	}
+	if int64(len(data)) > d.maxBytes {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("data exceed limit")
+	}
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/jpeg") {
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("not a JPEG image")
+	}
+
+	now := time.Now()
+	return &Object{
+		Data:        data,
+		ContentType: contentType,
+		FetchedAt:   now,
+		Expires:     now.Add(dataTTL),
+	}, http.StatusOK, nil
+}
+
+// decodeBase64 принимает стандартный и URL-вариант base64, с дополнением = и без него.
+func decodeBase64(payload string) ([]byte, error) {
+	payload = strings.TrimRight(payload, "=")
+	if strings.ContainsAny(payload, "-_") {
+		return base64.RawURLEncoding.DecodeString(payload)
+	}
+	return base64.RawStdEncoding.DecodeString(payload)
+}
@@ -0,0 +1,50 @@
+package source
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataSource(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+
+	data := NewData(1 << 20)
+	cases := []struct {
+		name   string
+		ref    string
+		status int
+	}{
+		{"standard base64", "image/jpeg;base64," + base64.StdEncoding.EncodeToString(img), http.StatusOK},
+		{"url-safe base64", "image/jpeg;base64," + base64.RawURLEncoding.EncodeToString(img), http.StatusOK},
+		{"percent-encoded", "image/jpeg," + url.PathEscape(string(img)), http.StatusOK},
+		{"no media type", ";base64," + base64.StdEncoding.EncodeToString(img), http.StatusOK},
+		{"other media type", "image/png;base64," + base64.StdEncoding.EncodeToString(img), http.StatusUnsupportedMediaType},
+		{"not a jpeg", "image/jpeg;base64," + base64.StdEncoding.EncodeToString([]byte("text")), http.StatusUnsupportedMediaType},
+		{"malformed", "image/jpeg;base64", http.StatusBadRequest},
+		{"broken base64", "image/jpeg;base64,!!!", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			object, status, err := data.Fetch(context.Background(), Request{Ref: tc.ref})
+			require.Equal(t, tc.status, status)
+			if tc.status != http.StatusOK {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, img, object.Data)
+		})
+	}
+
+	_, status, err := NewData(int64(len(img)-1)).Fetch(context.Background(),
+		Request{Ref: "image/jpeg;base64," + base64.StdEncoding.EncodeToString(img)})
+	require.Error(t, err)
+	require.Equal(t, http.StatusRequestEntityTooLarge, status)
+}
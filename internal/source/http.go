@@ -0,0 +1,276 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+)
+
+// DefaultMaxBytes лимит размера исходника, если он не задан в конфигурации.
+const DefaultMaxBytes = 100 << 20
+
+// credentialHeaders заголовки клиента с его учетными данными. Источнику они не передаются:
+// исходник и превью из него кэшируются по ссылке и отдаются всем клиентам.
+var credentialHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// HTTP скачивает исходники по http(s): ссылка host/path/img.jpg запрашивается
+// сначала по https, при ошибке соединения - по http. Временные сбои повторяются,
+// недоступные хосты отсекаются circuit breaker.
+type HTTP struct {
+	client   *http.Client
+	logger   Logger
+	retry    retryPolicy
+	upstream config.UpstreamCfg
+	breakers *breakers
+}
+
+func NewHTTP(upstream config.UpstreamCfg, breaker config.BreakerCfg, logger Logger) *HTTP {
+	transport := &http.Transport{
+		DisableKeepAlives: false,
+	}
+	attempts := upstream.RetryAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	if upstream.MaxSourceBytes <= 0 {
+		upstream.MaxSourceBytes = DefaultMaxBytes
+	}
+	return &HTTP{
+		client: &http.Client{Transport: transport},
+		logger: logger,
+		retry: retryPolicy{
+			attempts:  attempts,
+			baseDelay: upstream.RetryBaseDelay,
+			maxDelay:  upstream.RetryMaxDelay,
+		},
+		upstream: upstream,
+		breakers: newBreakers(breaker),
+	}
+}
+
+// Metrics возвращает состояние circuit breaker хостов.
+func (h *HTTP) Metrics() map[string]interface{} {
+	return map[string]interface{}{
+		"breakers": h.breakers.states(),
+	}
+}
+
+// Fetch проксирует заголовки клиента, кроме учетных данных, к источнику и скачивает исходник.
+// Если есть кэшированная копия, запрос отправляется с ее валидаторами.
+func (h *HTTP) Fetch(ctx context.Context, req Request) (*Object, int, error) {
+	targetURL := "https://" + req.Ref
+	targetReq, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	h.logger.Info(targetURL)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("error creating request: %w", err)
+	}
+
+	// Копируем заголовки из исходного запроса в новый
+	for name, values := range req.Header {
+		for _, value := range values {
+			targetReq.Header.Add(name, value)
+		}
+	}
+	for _, name := range credentialHeaders {
+		targetReq.Header.Del(name)
+	}
+	setValidators(targetReq, req.Cached)
+	return h.Do(targetReq)
+}
+
+// Do выполняет загрузку. Временные сбои источника
+// (5xx, разрывы соединения, таймауты) повторяются согласно retryPolicy,
+// при этом все попытки укладываются в общий срок Upstream.Timeout.
+// Ответ 304 возвращается объектом только со сроком свежести.
+func (h *HTTP) Do(targetReq *http.Request) (*Object, int, error) {
+	// Если источник недавно перестал отвечать, не ждем таймаута соединения, а сразу отказываем
+	host := targetReq.URL.Host
+	if err := h.breakers.allow(host); err != nil {
+		h.logger.Warn(fmt.Sprintf("%s: %s", host, err))
+		return nil, http.StatusServiceUnavailable, fmt.Errorf("%w for %s", err, host)
+	}
+
+	ctx, cancel := h.upstreamContext(targetReq.Context())
+	defer cancel()
+
+	// Отправляем запрос и обрабатываем ответ
+	targetResp, err := h.doWithRetry(targetReq.WithContext(ctx))
+	if err != nil {
+		if targetReq.Context().Err() != nil {
+			h.breakers.done(host, resultIgnored)
+		} else {
+			h.breakers.done(host, resultFailure)
+		}
+		h.logger.Error(fmt.Sprintf("Status %d, %s", http.StatusBadGateway, err.Error()))
+		return nil, http.StatusBadGateway, fmt.Errorf("error sending request")
+	}
+	defer func() {
+		if err := targetResp.Body.Close(); err != nil {
+			return
+		}
+	}()
+
+	// Источник так и не ответил успешно после всех попыток
+	if targetResp.StatusCode >= http.StatusInternalServerError || targetResp.StatusCode == http.StatusTooManyRequests {
+		h.breakers.done(host, resultFailure)
+		return nil, http.StatusBadGateway, fmt.Errorf("upstream error: %s", targetResp.Status)
+	}
+	h.breakers.done(host, resultSuccess)
+
+	// Исходник не изменился с прошлой загрузки
+	if targetResp.StatusCode == http.StatusNotModified {
+		return &Object{Expires: freshUntil(targetResp.Header, time.Now(), h.upstream.DefaultTTL)}, http.StatusNotModified, nil
+	}
+
+	// Проверяем, что внешний сервис не ответил 404
+	if targetResp.StatusCode == http.StatusNotFound {
+		return nil, targetResp.StatusCode, fmt.Errorf("content not found")
+	}
+
+	// Проверяем, что внешний сервис отправляет jpeg, если да, то читаем его через буфер.
+	contentType := targetResp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/jpeg") {
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("not a JPEG image")
+	}
+
+	// отказываемся сразу, если источник заранее сообщил, что файл больше лимита
+	if targetResp.ContentLength > h.upstream.MaxSourceBytes {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("data exceed limit")
+	}
+
+	// скачиваем ответ через буфер, что бы не получить слишком большой файл
+	//  и прекратить чтение при превышении лимита
+	h.logger.Info("JPEG image receiving")
+	result, status, err := h.responseBufferReader(targetResp.Body, targetResp.ContentLength)
+	if err != nil {
+		return nil, status, err
+	}
+	h.logger.Info("JPEG image received")
+	return &Object{
+		Data:         result,
+		ContentType:  contentType,
+		ETag:         targetResp.Header.Get("ETag"),
+		LastModified: targetResp.Header.Get("Last-Modified"),
+		FetchedAt:    time.Now(),
+		Expires:      freshUntil(targetResp.Header, time.Now(), h.upstream.DefaultTTL),
+		MaxAge:       maxAge(targetResp.Header),
+	}, http.StatusOK, nil
+}
+
+// upstreamContext ограничивает запрос к источнику сроком Upstream.Timeout, если он задан.
+func (h *HTTP) upstreamContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if h.upstream.Timeout > 0 {
+		return context.WithTimeout(parent, h.upstream.Timeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// responseBufferReader читает файл из источника до конца файла или достижения
+// лимита Upstream.MaxSourceBytes. Буфер заранее выделяется по Content-Length, если он известен.
+// Если лимит превышен, возвращает ошибку со статусом 413.
+func (h *HTTP) responseBufferReader(targetBody io.Reader, contentLength int64) ([]byte, int, error) {
+	// маловероятно что jpeg будет весить больше лимита,
+	// если будет превышение возможно там не jpeg замаскированный под jpeg.
+	limitBytes := h.upstream.MaxSourceBytes
+	buffer := &bytes.Buffer{}
+	if contentLength > 0 && contentLength <= limitBytes {
+		buffer.Grow(int(contentLength))
+	}
+
+	// читаем на байт больше лимита, чтобы отличить файл ровно в лимит от превышающего его
+	bytesRead, err := io.CopyN(buffer, targetBody, limitBytes+1)
+	switch {
+	case err == nil:
+		h.logger.Info(fmt.Sprintf("Received more than %d bytes, limit exceeded", limitBytes))
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("data exceed limit")
+	case errors.Is(err, io.EOF):
+		h.logger.Info(fmt.Sprintf("Received %d bytes", bytesRead))
+		return buffer.Bytes(), http.StatusOK, nil
+	default:
+		h.logger.Info(fmt.Sprintf("Received %d bytes", bytesRead))
+		return nil, http.StatusBadGateway, fmt.Errorf("error reading request body: %w", err)
+	}
+}
+
+// freshUntil вычисляет, до какого момента ответ источника можно считать свежим,
+// по заголовкам Cache-Control (s-maxage, max-age, no-cache, no-store) и Expires.
+// Если источник ничего не указал, ответ свежий в течение defaultTTL.
+func freshUntil(header http.Header, now time.Time, defaultTTL time.Duration) time.Time {
+	directives := parseCacheControl(header.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return now
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return now
+	}
+
+	if lifetime, ok := cacheControlLifetime(header, directives); ok {
+		return now.Add(lifetime)
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			// некорректный Expires означает "уже устарел"
+			return now
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		return now.Add(expiresAt.Sub(date))
+	}
+
+	return now.Add(defaultTTL)
+}
+
+// maxAge срок хранения, указанный источником в Cache-Control, 0 - источник его не указал
+// или запретил хранение.
+func maxAge(header http.Header) time.Duration {
+	directives := parseCacheControl(header.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return 0
+	}
+	if lifetime, ok := cacheControlLifetime(header, directives); ok && lifetime > 0 {
+		return lifetime
+	}
+	return 0
+}
+
+// cacheControlLifetime оставшийся срок свежести ответа по s-maxage или max-age с учетом Age.
+func cacheControlLifetime(header http.Header, directives map[string]string) (time.Duration, bool) {
+	age := time.Duration(0)
+	if seconds, err := strconv.Atoi(header.Get("Age")); err == nil && seconds > 0 {
+		age = time.Duration(seconds) * time.Second
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[name]; ok {
+			if seconds, err := strconv.Atoi(value); err == nil {
+				return time.Duration(seconds)*time.Second - age, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// parseCacheControl разбирает заголовок Cache-Control в набор директив.
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(part, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+	}
+	return directives
+}
@@ -0,0 +1,113 @@
+package source
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreshUntil(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	header := func(kv ...string) http.Header {
+		h := http.Header{}
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+
+	require.Equal(t, now.Add(time.Hour), freshUntil(header(), now, time.Hour))
+	require.Equal(t, now.Add(60*time.Second), freshUntil(header("Cache-Control", "public, max-age=60"), now, time.Hour))
+	require.Equal(t, now.Add(30*time.Second),
+		freshUntil(header("Cache-Control", "max-age=60, s-maxage=40", "Age", "10"), now, time.Hour))
+	require.Equal(t, now, freshUntil(header("Cache-Control", "no-cache"), now, time.Hour))
+	require.Equal(t, now.Add(2*time.Minute), freshUntil(header(
+		"Date", now.Add(-time.Minute).Format(http.TimeFormat),
+		"Expires", now.Add(time.Minute).Format(http.TimeFormat),
+	), now, time.Hour))
+	require.Equal(t, now, freshUntil(header("Expires", "0"), now, time.Hour))
+}
+
+func TestMaxAge(t *testing.T) {
+	header := func(cacheControl, age string) http.Header {
+		h := http.Header{}
+		h.Set("Cache-Control", cacheControl)
+		h.Set("Age", age)
+		return h
+	}
+
+	require.Equal(t, time.Minute, maxAge(header("public, max-age=60", "")))
+	require.Equal(t, 30*time.Second, maxAge(header("max-age=60, s-maxage=40", "10")))
+	require.Zero(t, maxAge(header("max-age=60", "120")))
+	require.Zero(t, maxAge(header("max-age=60, no-store", "")))
+	require.Zero(t, maxAge(http.Header{"Expires": {"Mon, 01 Jan 2024 00:00:00 GMT"}}))
+}
+
+func TestHTTPSizeLimit(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		if r.URL.Path == "/chunked.jpg" {
+			// без Content-Length лимит проверяется при чтении
+			w.(http.Flusher).Flush()
+		}
+		w.Write(img)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	web := newTestHTTP(config.UpstreamCfg{
+		Timeout:        5 * time.Second,
+		RetryAttempts:  1,
+		MaxSourceBytes: int64(len(img) - 1),
+	})
+	for _, path := range []string{"/declared.jpg", "/chunked.jpg"} {
+		_, status, err := web.Fetch(context.Background(), Request{Ref: host + path})
+		require.Error(t, err, path)
+		require.Equal(t, http.StatusRequestEntityTooLarge, status, path)
+	}
+
+	web.upstream.MaxSourceBytes = int64(len(img))
+	object, status, err := web.Fetch(context.Background(), Request{Ref: host + "/chunked.jpg"})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, img, object.Data)
+}
+
+func TestHTTPStripsCredentials(t *testing.T) {
+	received := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("image"))
+	}))
+	defer srv.Close()
+
+	web := newTestHTTP(config.UpstreamCfg{Timeout: 5 * time.Second, RetryAttempts: 1})
+	header := http.Header{
+		"Authorization":       {"Bearer secret"},
+		"Cookie":              {"session=secret"},
+		"Proxy-Authorization": {"Basic secret"},
+		"Accept-Language":     {"ru"},
+	}
+	_, status, err := web.Fetch(context.Background(), Request{Ref: strings.TrimPrefix(srv.URL, "http://") + "/img.jpg", Header: header})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+
+	upstream := <-received
+	require.Empty(t, upstream.Get("Authorization"))
+	require.Empty(t, upstream.Get("Cookie"))
+	require.Empty(t, upstream.Get("Proxy-Authorization"))
+	require.Equal(t, "ru", upstream.Get("Accept-Language"))
+	// заголовки клиента не меняются
+	require.Equal(t, "Bearer secret", header.Get("Authorization"))
+}
@@ -0,0 +1,174 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+)
+
+// localPrefix префикс ссылок на исходники из локальных каталогов:
+// /fill/300/200/local/{root-name}/path/to/img.jpg.
+const localPrefix = "local/"
+
+var errForbiddenPath = errors.New("path is outside of local root")
+
+// Local читает исходники из каталогов, заданных в конфигурации.
+// Путь не может выйти за пределы каталога ни через "..", ни через символические ссылки.
+// Валидатором служит время изменения файла.
+type Local struct {
+	roots          map[string]string
+	followSymlinks bool
+	maxBytes       int64
+	// checked вызывается между проверкой пути и открытием файла, в тестах подменяет файл.
+	checked func(path string)
+}
+
+func NewLocal(cfg config.LocalCfg, maxBytes int64, logger Logger) *Local {
+	roots := make(map[string]string, len(cfg.Roots))
+	for name, dir := range cfg.Roots {
+		abs, err := filepath.Abs(dir)
+		if err == nil {
+			// сам каталог может быть ссылкой, сравнивать будем с его настоящим путем
+			abs, err = filepath.EvalSymlinks(abs)
+		}
+		if err != nil {
+			logger.Error(fmt.Sprintf("local root %s (%s) is unavailable: %s", name, dir, err))
+			continue
+		}
+		roots[name] = abs
+	}
+	return &Local{roots: roots, followSymlinks: cfg.FollowSymlinks, maxBytes: maxBytes}
+}
+
+// resolve превращает ссылку {root-name}/path/to/img.jpg в путь к файлу.
+func (l *Local) resolve(ref string) (string, int, error) {
+	name, rel, _ := strings.Cut(strings.TrimPrefix(ref, "/"), "/")
+	root, ok := l.roots[name]
+	if !ok {
+		return "", http.StatusNotFound, fmt.Errorf("unknown local root: %s", name)
+	}
+
+	for _, part := range strings.Split(rel, "/") {
+		if part == ".." {
+			return "", http.StatusForbidden, errForbiddenPath
+		}
+	}
+	rel = filepath.FromSlash(strings.Trim(rel, "/"))
+	if rel == "" || filepath.IsAbs(rel) {
+		return "", http.StatusForbidden, errForbiddenPath
+	}
+	path := filepath.Join(root, rel)
+
+	if l.followSymlinks {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return "", http.StatusNotFound, fmt.Errorf("content not found")
+		}
+		if !within(root, resolved) {
+			return "", http.StatusForbidden, errForbiddenPath
+		}
+		return resolved, http.StatusOK, nil
+	}
+
+	// без разрешения на ссылки проверяем каждый компонент пути
+	current := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			return "", http.StatusNotFound, fmt.Errorf("content not found")
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", http.StatusForbidden, fmt.Errorf("symlinks are not allowed: %w", errForbiddenPath)
+		}
+	}
+	return path, http.StatusOK, nil
+}
+
+// open открывает файл по ссылке и убеждается, что открыт именно проверенный файл:
+// между проверкой пути и открытием компонент пути могли подменить символической ссылкой.
+// Поэтому после открытия путь проверяется заново и сравнивается с открытым файлом.
+func (l *Local) open(ref string) (*os.File, os.FileInfo, int, error) {
+	path, status, err := l.resolve(ref)
+	if err != nil {
+		return nil, nil, status, err
+	}
+	if l.checked != nil {
+		l.checked(path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, http.StatusNotFound, fmt.Errorf("content not found")
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		file.Close()
+		return nil, nil, http.StatusNotFound, fmt.Errorf("content not found")
+	}
+
+	checked, status, err := l.resolve(ref)
+	if err != nil {
+		file.Close()
+		return nil, nil, status, err
+	}
+	current, err := os.Lstat(checked)
+	if err != nil || !os.SameFile(info, current) {
+		file.Close()
+		return nil, nil, http.StatusForbidden, fmt.Errorf("file changed while opening: %w", errForbiddenPath)
+	}
+	return file, info, http.StatusOK, nil
+}
+
+// Fetch читает исходник по ссылке {root-name}/path/to/img.jpg.
+// Если файл не изменился со времени req.Cached, возвращает 304.
+func (l *Local) Fetch(_ context.Context, req Request) (*Object, int, error) {
+	file, info, status, err := l.open(req.Ref)
+	if err != nil {
+		return nil, status, err
+	}
+	defer file.Close()
+	// перепроверка файла дешевая, поэтому он считается устаревшим сразу
+	now := time.Now()
+	lastModified := info.ModTime().UTC().Format(http.TimeFormat)
+	if cached := req.Cached; cached != nil && cached.LastModified == lastModified && cached.Size() == info.Size() {
+		return &Object{Expires: now}, http.StatusNotModified, nil
+	}
+	if info.Size() > l.maxBytes {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("data exceed limit")
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, l.maxBytes+1))
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("error reading file: %w", err)
+	}
+	if int64(len(data)) > l.maxBytes {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("data exceed limit")
+	}
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/jpeg") {
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("not a JPEG image")
+	}
+
+	return &Object{
+		Data:         data,
+		ContentType:  contentType,
+		LastModified: lastModified,
+		FetchedAt:    now,
+		Expires:      now,
+	}, http.StatusOK, nil
+}
+
+// within сообщает, что path лежит внутри каталога root.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,101 @@
+package source
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalSource(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+
+	base := t.TempDir()
+	root := filepath.Join(base, "photos")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "animals"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "animals", "beaver.jpg"), img, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "notes.txt"), []byte("this is text"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(base, "secret.jpg"), img, 0o600))
+	require.NoError(t, os.Symlink(filepath.Join(root, "animals", "beaver.jpg"), filepath.Join(root, "inside.jpg")))
+	require.NoError(t, os.Symlink(filepath.Join(base, "secret.jpg"), filepath.Join(root, "outside.jpg")))
+
+	cfg := config.LocalCfg{Roots: map[string]string{"photos": root}}
+	strict := NewLocal(cfg, 1<<20, nopLogger{})
+	cfg.FollowSymlinks = true
+	follow := NewLocal(cfg, 1<<20, nopLogger{})
+
+	cases := []struct {
+		name   string
+		source *Local
+		ref    string
+		status int
+	}{
+		{"regular file", strict, "photos/animals/beaver.jpg", http.StatusOK},
+		{"unknown root", strict, "videos/animals/beaver.jpg", http.StatusNotFound},
+		{"missing file", strict, "photos/animals/marmot.jpg", http.StatusNotFound},
+		{"directory", strict, "photos/animals", http.StatusNotFound},
+		{"traversal", strict, "photos/../secret.jpg", http.StatusForbidden},
+		{"not a jpeg", strict, "photos/notes.txt", http.StatusUnsupportedMediaType},
+		{"symlink not allowed", strict, "photos/inside.jpg", http.StatusForbidden},
+		{"symlink inside root", follow, "photos/inside.jpg", http.StatusOK},
+		{"symlink outside root", follow, "photos/outside.jpg", http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			object, status, err := tc.source.Fetch(context.Background(), Request{Ref: tc.ref})
+			require.Equal(t, tc.status, status)
+			if tc.status != http.StatusOK {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, img, object.Data)
+			require.NotEmpty(t, object.LastModified)
+		})
+	}
+
+	t.Run("unchanged file is not modified", func(t *testing.T) {
+		req := Request{Ref: "photos/animals/beaver.jpg"}
+		object, _, err := strict.Fetch(context.Background(), req)
+		require.NoError(t, err)
+		req.Cached = object
+		_, status, err := strict.Fetch(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNotModified, status)
+
+		later := time.Now().Add(time.Hour)
+		require.NoError(t, os.Chtimes(filepath.Join(root, "animals", "beaver.jpg"), later, later))
+		_, status, err = strict.Fetch(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, status)
+	})
+
+	t.Run("file replaced by symlink while opening", func(t *testing.T) {
+		swap := filepath.Join(root, "swap.jpg")
+		require.NoError(t, os.WriteFile(swap, img, 0o600))
+		strict.checked = func(path string) {
+			require.NoError(t, os.Remove(path))
+			require.NoError(t, os.Symlink(filepath.Join(base, "secret.jpg"), path))
+		}
+		defer func() { strict.checked = nil }()
+
+		_, status, err := strict.Fetch(context.Background(), Request{Ref: "photos/swap.jpg"})
+		require.ErrorIs(t, err, errForbiddenPath)
+		require.Equal(t, http.StatusForbidden, status)
+	})
+
+	t.Run("resolved by prefix", func(t *testing.T) {
+		r := NewDefault(config.Config{Local: cfg}, nopLogger{})
+		src, ref := r.Resolve("local/photos/animals/beaver.jpg")
+		object, status, err := src.Fetch(context.Background(), Request{Ref: ref})
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, img, object.Data)
+	})
+}
@@ -0,0 +1,161 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// retryPolicy описывает повторные запросы к источнику при временных сбоях.
+type retryPolicy struct {
+	attempts  int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// backoff возвращает паузу перед следующей попыткой после неудачной попытки attempt (с 1):
+// экспоненциальный рост от baseDelay до maxDelay со случайным разбросом ("full jitter").
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	limit := p.maxDelay
+	if attempt-1 < 32 {
+		if d := p.baseDelay << (attempt - 1); d > 0 && d < limit {
+			limit = d
+		}
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(limit) + 1)) //nolint:gosec
+}
+
+// doWithRetry отправляет запрос и повторяет его при временных ошибках.
+// Все попытки и паузы укладываются в срок контекста запроса:
+// если до дедлайна не хватает времени на паузу, возвращается результат последней попытки.
+func (h *HTTP) doWithRetry(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		resp, err := h.sendRequest(req)
+		if attempt >= h.retry.attempts || !idempotent(req.Method) {
+			return resp, err
+		}
+
+		var wait time.Duration
+		switch {
+		case err != nil:
+			if ctx.Err() != nil || !retryableError(err) {
+				return nil, err
+			}
+			wait = h.retry.backoff(attempt)
+		case retryableStatus(resp.StatusCode):
+			wait = h.retry.backoff(attempt)
+			if after, ok := retryAfter(resp.Header, time.Now()); ok && after > wait {
+				wait = after
+			}
+		default:
+			return resp, nil
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body) //nolint:errcheck
+			resp.Body.Close()
+			h.logger.Warn(fmt.Sprintf("upstream answered %d, retry %d in %s", resp.StatusCode, attempt, wait))
+		} else {
+			h.logger.Warn(fmt.Sprintf("upstream error: %s, retry %d in %s", err, attempt, wait))
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// sendRequest выполняет одну попытку: сначала по https, при ошибке соединения — по http.
+// Запросы с авторизацией (например, подписанные запросы к S3) по http не повторяются.
+func (h *HTTP) sendRequest(req *http.Request) (*http.Response, error) {
+	resp, err := h.client.Do(req)
+	if err == nil || req.URL.Scheme != "https" || req.Header.Get("Authorization") != "" {
+		return resp, err
+	}
+	h.logger.Error(err.Error())
+	plainReq := req.Clone(req.Context())
+	plainReq.URL.Scheme = "http"
+	return h.client.Do(plainReq)
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// retryableStatus сообщает, что ответ источника говорит о временной проблеме.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryableError отделяет временные сетевые сбои (таймауты, разрывы соединения)
+// от постоянных, например, ошибки разрешения имени хоста.
+func retryableError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryAfter разбирает заголовок Retry-After в секундах или в формате HTTP-даты.
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := date.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
@@ -0,0 +1,122 @@
+package source
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string)  {}
+func (nopLogger) Error(string) {}
+func (nopLogger) Debug(string) {}
+func (nopLogger) Warn(string)  {}
+
+func newTestHTTP(upstream config.UpstreamCfg) *HTTP {
+	return NewHTTP(upstream, config.BreakerCfg{}, nopLogger{})
+}
+
+func TestBackoff(t *testing.T) {
+	p := retryPolicy{attempts: 5, baseDelay: 10 * time.Millisecond, maxDelay: 50 * time.Millisecond}
+	for attempt := 1; attempt < 40; attempt++ {
+		d := p.backoff(attempt)
+		require.GreaterOrEqual(t, d, time.Duration(0))
+		require.LessOrEqual(t, d, 50*time.Millisecond)
+	}
+	require.LessOrEqual(t, p.backoff(1), 10*time.Millisecond)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	h := http.Header{}
+	_, ok := retryAfter(h, now)
+	require.False(t, ok)
+
+	h.Set("Retry-After", "3")
+	d, ok := retryAfter(h, now)
+	require.True(t, ok)
+	require.Equal(t, 3*time.Second, d)
+
+	h.Set("Retry-After", now.Add(5*time.Second).Format(http.TimeFormat))
+	d, ok = retryAfter(h, now)
+	require.True(t, ok)
+	require.Equal(t, 5*time.Second, d)
+
+	h.Set("Retry-After", "soon")
+	_, ok = retryAfter(h, now)
+	require.False(t, ok)
+}
+
+func TestDoWithRetry(t *testing.T) {
+	cfg := config.UpstreamCfg{
+		Timeout:        time.Second,
+		RetryAttempts:  3,
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  5 * time.Millisecond,
+	}
+
+	t.Run("recovers after transient 503", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			if calls.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer srv.Close()
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		resp, err := newTestHTTP(cfg).doWithRetry(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer srv.Close()
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		resp, err := newTestHTTP(cfg).doWithRetry(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+		require.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("stops when Retry-After exceeds deadline", func(t *testing.T) {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		resp, err := newTestHTTP(cfg).doWithRetry(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		require.Equal(t, int32(1), calls.Load())
+	})
+}
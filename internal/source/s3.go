@@ -0,0 +1,46 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/Ser9unin/ImagePreviewer/internal/s3"
+)
+
+// s3Prefix префикс ссылок на исходники из S3-совместимого хранилища:
+// /fill/300/200/s3/{bucket}/path/to/img.jpg.
+const s3Prefix = "s3/"
+
+// S3 скачивает исходники из приватных бакетов подписанными запросами.
+// Запросы идут тем же путем, что и http-источники: с повторами, circuit breaker и лимитом размера.
+type S3 struct {
+	client *s3.Client
+	web    *HTTP
+}
+
+func NewS3(cfg config.S3Cfg, web *HTTP, logger Logger) *S3 {
+	client, err := s3.NewClient(cfg)
+	if err != nil && !errors.Is(err, s3.ErrNotConfigured) {
+		logger.Error(err.Error())
+	}
+	return &S3{client: client, web: web}
+}
+
+// Fetch скачивает объект по ссылке {bucket}/path/to/img.jpg.
+func (s *S3) Fetch(ctx context.Context, req Request) (*Object, int, error) {
+	if s.client == nil {
+		return nil, http.StatusNotFound, s3.ErrNotConfigured
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(req.Ref, "/"), "/")
+	targetReq, err := s.client.NewGetObjectRequest(ctx, bucket, key)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("wrong s3 object: %w", err)
+	}
+	setValidators(targetReq, req.Cached)
+	return s.web.Do(targetReq)
+}
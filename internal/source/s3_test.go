@@ -0,0 +1,56 @@
+package source
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+	"github.com/Ser9unin/ImagePreviewer/internal/s3"
+	"github.com/Ser9unin/ImagePreviewer/internal/s3/s3test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3Source(t *testing.T) {
+	img, err := os.ReadFile("../../test_images/beaver_cute.jpg")
+	require.NoError(t, err)
+
+	creds := s3.Credentials{AccessKey: "minio", SecretKey: "minio-secret"}
+	srv := s3test.NewServer(creds, "us-east-1")
+	defer srv.Close()
+	srv.Put("private", "animals/beaver.jpg", img, "image/jpeg")
+
+	r := NewDefault(config.Config{
+		Upstream: config.UpstreamCfg{Timeout: 5 * time.Second, RetryAttempts: 1},
+		S3: config.S3Cfg{
+			Endpoint:  srv.URL,
+			AccessKey: creds.AccessKey,
+			SecretKey: creds.SecretKey,
+			PathStyle: true,
+		},
+	}, nopLogger{})
+
+	fetch := func(ref string, cached *Object) (*Object, int, error) {
+		src, rest := r.Resolve(ref)
+		require.IsType(t, &S3{}, src)
+		return src.Fetch(context.Background(), Request{Ref: rest, Header: http.Header{}, Cached: cached})
+	}
+
+	object, status, err := fetch("s3/private/animals/beaver.jpg", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, img, object.Data)
+	require.NotEmpty(t, object.ETag)
+
+	// кэшированная копия перепроверяется по ETag
+	_, status, err = fetch("s3/private/animals/beaver.jpg", object)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotModified, status)
+	require.Equal(t, 2, srv.Requests())
+
+	_, status, err = fetch("s3/private/animals/marmot.jpg", nil)
+	require.Error(t, err)
+	require.Equal(t, http.StatusNotFound, status)
+}
@@ -0,0 +1,145 @@
+// Package source источники исходных изображений: http(s), локальные каталоги,
+// S3-совместимые хранилища и data: URI. Источник выбирается в Registry
+// по префиксу ссылки, новые источники подключаются через Register.
+package source
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Ser9unin/ImagePreviewer/internal/config"
+)
+
+// Object исходное изображение вместе с валидаторами,
+// по которым его можно перепроверить у источника.
+type Object struct {
+	Data         []byte
+	ContentType  string
+	ETag         string
+	LastModified string
+	FetchedAt    time.Time
+	// Expires срок свежести, например, по Cache-Control/Expires источника.
+	Expires time.Time
+	// MaxAge срок хранения, указанный источником в Cache-Control (s-maxage, max-age),
+	// 0 - источник его не указал.
+	MaxAge time.Duration
+}
+
+// Size объем изображения в байтах.
+func (o *Object) Size() int64 {
+	return int64(len(o.Data))
+}
+
+// Request запрос исходника у источника.
+type Request struct {
+	// Ref ссылка на исходник без префикса источника, например, host/path/img.jpg для http.
+	Ref string
+	// Header заголовки запроса клиента, которые проксируются источнику.
+	Header http.Header
+	// Cached ранее полученная копия (может быть nil). Если она еще действительна,
+	// источник отвечает статусом 304 и объектом только с новым сроком свежести.
+	Cached *Object
+}
+
+// Source источник исходных изображений.
+// Возвращает объект и http-статус, с которым нужно ответить клиенту при ошибке.
+type Source interface {
+	Fetch(ctx context.Context, req Request) (*Object, int, error)
+}
+
+// Reporter источник, который сообщает свое состояние для /metrics.
+type Reporter interface {
+	Metrics() map[string]interface{}
+}
+
+type Logger interface {
+	Info(msg string)
+	Error(msg string)
+	Debug(msg string)
+	Warn(msg string)
+}
+
+// Registry выбирает источник по префиксу ссылки: local/, s3/, data: и т.п.
+// Ссылки без известного префикса обслуживает источник по умолчанию.
+type Registry struct {
+	fallback Source
+	sources  map[string]Source
+	// prefixes отсортированы по убыванию длины, чтобы выигрывал самый длинный префикс.
+	prefixes []string
+}
+
+func NewRegistry(fallback Source) *Registry {
+	return &Registry{fallback: fallback, sources: make(map[string]Source)}
+}
+
+// NewDefault реестр со всеми встроенными источниками:
+// http(s) по умолчанию, local/{root}/..., s3/{bucket}/... и data: URI.
+func NewDefault(cfg config.Config, logger Logger) *Registry {
+	if cfg.Upstream.MaxSourceBytes <= 0 {
+		cfg.Upstream.MaxSourceBytes = DefaultMaxBytes
+	}
+	web := NewHTTP(cfg.Upstream, cfg.Breaker, logger)
+	r := NewRegistry(web)
+	r.Register(localPrefix, NewLocal(cfg.Local, cfg.Upstream.MaxSourceBytes, logger))
+	r.Register(s3Prefix, NewS3(cfg.S3, web, logger))
+	r.Register(DataPrefix, NewData(cfg.Upstream.MaxSourceBytes))
+	return r
+}
+
+// Register подключает источник для ссылок, начинающихся с prefix.
+func (r *Registry) Register(prefix string, src Source) {
+	if _, ok := r.sources[prefix]; !ok {
+		r.prefixes = append(r.prefixes, prefix)
+		sort.SliceStable(r.prefixes, func(i, j int) bool {
+			return len(r.prefixes[i]) > len(r.prefixes[j])
+		})
+	}
+	r.sources[prefix] = src
+}
+
+// Resolve возвращает источник для ссылки и ссылку без его префикса.
+func (r *Registry) Resolve(ref string) (Source, string) {
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(ref, prefix) {
+			return r.sources[prefix], strings.TrimPrefix(ref, prefix)
+		}
+	}
+	return r.fallback, ref
+}
+
+// Metrics собирает состояние источников, которые его сообщают.
+func (r *Registry) Metrics() map[string]interface{} {
+	metrics := make(map[string]interface{})
+	sources := []Source{r.fallback}
+	for _, prefix := range r.prefixes {
+		sources = append(sources, r.sources[prefix])
+	}
+	for _, src := range sources {
+		if reporter, ok := src.(Reporter); ok {
+			for name, value := range reporter.Metrics() {
+				metrics[name] = value
+			}
+		}
+	}
+	return metrics
+}
+
+// setValidators добавляет в запрос валидаторы кэшированной копии (If-None-Match/If-Modified-Since).
+// Условные заголовки клиента относятся к его собственному кэшу, а не к нашему, и удаляются.
+func setValidators(targetReq *http.Request, cached *Object) {
+	targetReq.Header.Del("If-None-Match")
+	targetReq.Header.Del("If-Modified-Since")
+
+	if cached == nil {
+		return
+	}
+	if cached.ETag != "" {
+		targetReq.Header.Set("If-None-Match", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		targetReq.Header.Set("If-Modified-Since", cached.LastModified)
+	}
+}
@@ -0,0 +1,40 @@
+package source
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type stubSource string
+
+func (s stubSource) Fetch(context.Context, Request) (*Object, int, error) {
+	return &Object{Data: []byte(s)}, 200, nil
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(stubSource("web"))
+	r.Register("img/", stubSource("img"))
+	r.Register("img/raw/", stubSource("raw"))
+
+	cases := []struct {
+		ref, source, rest string
+	}{
+		{"example.com/beaver.jpg", "web", "example.com/beaver.jpg"},
+		{"img/beaver.jpg", "img", "beaver.jpg"},
+		{"img/raw/beaver.jpg", "raw", "beaver.jpg"},
+		{"images/beaver.jpg", "web", "images/beaver.jpg"},
+	}
+	for _, tc := range cases {
+		src, rest := r.Resolve(tc.ref)
+		require.Equal(t, stubSource(tc.source), src, tc.ref)
+		require.Equal(t, tc.rest, rest, tc.ref)
+	}
+
+	// источник можно заменить, повторная регистрация не дублирует префикс
+	r.Register("img/", stubSource("img2"))
+	src, _ := r.Resolve("img/beaver.jpg")
+	require.Equal(t, stubSource("img2"), src)
+	require.Len(t, r.prefixes, 2)
+}